@@ -47,6 +47,12 @@ const (
 	SEQUENCE_CUSTOM          = 0x13
 )
 
+// KEYBOARD_LAYOUT is drawn as LAYOUT_ROWS rows of LAYOUT_COLUMNS, top (Esc, F1...) row first, with "" for gaps
+const (
+	LAYOUT_COLUMNS = 21
+	LAYOUT_ROWS    = 6
+)
+
 // Imagine this is a const
 var KEYBOARD_LAYOUT = []string{
 	"ESC", "", "F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12", "KP7", "KP8", "KP9", "", "", "", "",
@@ -1,15 +1,20 @@
 package driver
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/fatih/color"
 	"github.com/sstallion/go-hid"
 )
 
+var ErrDisconnected = errors.New("device disconnected")
+
 type DrunkDeerController struct {
 	device   *hid.Device
 	identity *DDKeyboardIdentity
@@ -20,27 +25,130 @@ type DrunkDeerController struct {
 
 	turbo        bool
 	rapidTrigger bool
-	debug        bool
 
-	wg          sync.WaitGroup
-	packetChan  chan DDPacket
-	packetQueue chan []byte
+	logger *slog.Logger
+
+	wg         sync.WaitGroup
+	packetChan chan DDPacket
+	scheduler  *packetScheduler
+	pending    atomic.Int64 // Queued or in-flight reports, see Flush
+
+	disconnected   chan struct{}
+	disconnectOnce sync.Once
+	disconnectErr  error
 
 	Light *DDLight
 
+	subscribersMu  sync.Mutex
+	subscribers    map[int]chan DDPacket
+	nextSubscriber int
+
 	shouldClose bool
 	closeOnce   sync.Once
 }
 
+// Returns nil if the device goes away before answering
 func (d *DrunkDeerController) GetIdentity() *DDKeyboardIdentity {
+	identity, _ := d.WaitIdentity(context.Background())
+	return identity
+}
+
+// Doesn't query the device, nil until it has answered once
+func (d *DrunkDeerController) GetIdentityIfKnown() *DDKeyboardIdentity {
+	return d.identity
+}
+
+func (d *DrunkDeerController) WaitIdentity(ctx context.Context) (*DDKeyboardIdentity, error) {
 	if d.identity == nil {
-		d.SendIdentity()
-		for d.identity == nil && !d.shouldClose {
-			time.Sleep(50 * time.Millisecond)
+		if err := d.SendIdentity(); err != nil {
+			return nil, err
 		}
 	}
 
-	return d.identity
+	for d.identity == nil {
+		if d.shouldClose {
+			return nil, fmt.Errorf("controller closed")
+		}
+
+		select {
+		case <-d.disconnected:
+			return nil, d.disconnectErr
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	return d.identity, nil
+}
+
+// Disconnected is closed once a read or write to the device fails, the controller is useless after that
+func (d *DrunkDeerController) Disconnected() <-chan struct{} {
+	return d.disconnected
+}
+
+func (d *DrunkDeerController) markDisconnected(err error) {
+	d.disconnectOnce.Do(func() {
+		d.logger.Warn("device disconnected", slog.Any("err", err))
+		d.disconnectErr = fmt.Errorf("%w: %v", ErrDisconnected, err)
+		close(d.disconnected)
+	})
+}
+
+// Subscribe returns every inbound packet from now on. Slow subscribers miss packets rather than
+// stalling the receiver. Call the returned function to unsubscribe.
+func (d *DrunkDeerController) Subscribe() (<-chan DDPacket, func()) {
+	d.subscribersMu.Lock()
+	defer d.subscribersMu.Unlock()
+
+	id := d.nextSubscriber
+	d.nextSubscriber++
+
+	ch := make(chan DDPacket, 64)
+	d.subscribers[id] = ch
+
+	return ch, func() {
+		d.subscribersMu.Lock()
+		defer d.subscribersMu.Unlock()
+
+		if _, ok := d.subscribers[id]; ok {
+			delete(d.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+func (d *DrunkDeerController) publish(p DDPacket) {
+	d.subscribersMu.Lock()
+	defer d.subscribersMu.Unlock()
+
+	for _, ch := range d.subscribers {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// Flush waits until every queued report has been written to the device
+func (d *DrunkDeerController) Flush(ctx context.Context) error {
+	for d.pending.Load() > 0 {
+		select {
+		case <-d.disconnected:
+			return d.disconnectErr
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// A disconnect can drop the last reports without leaving anything pending
+	select {
+	case <-d.disconnected:
+		return d.disconnectErr
+	default:
+		return nil
+	}
 }
 
 func (d *DrunkDeerController) GetActuations() []byte {
@@ -49,8 +157,16 @@ func (d *DrunkDeerController) GetActuations() []byte {
 	return d.actuations
 }
 
-func (d *DrunkDeerController) sendReport(p []byte) {
-	d.Log("Sending report: %x", p)
+func (d *DrunkDeerController) GetDownstrokes() []byte {
+	return d.downstrokes
+}
+
+func (d *DrunkDeerController) GetUpstrokes() []byte {
+	return d.upstrokes
+}
+
+func (d *DrunkDeerController) sendReport(p []byte) error {
+	d.logger.Debug("sending report", reportAttrs(p)...)
 	report := make([]byte, 64)
 	report[0] = KEYBOARD_REPORT_ID // Report ID
 
@@ -62,67 +178,93 @@ func (d *DrunkDeerController) sendReport(p []byte) {
 
 	_, err := d.device.Write(report)
 	if err != nil {
-		panic(err)
+		d.markDisconnected(err)
+		return err
 	}
+
+	return nil
 }
 
-func (d *DrunkDeerController) SetDebug(debug bool) {
-	d.debug = debug
+func (d *DrunkDeerController) Logger() *slog.Logger {
+	return d.logger
 }
 
-func (d *DrunkDeerController) Log(str string, v ...interface{}) {
-	if str[len(str)-1] != '\n' {
-		str += "\n"
+// Packet type, row and payload as slog attributes
+func reportAttrs(p []byte) []any {
+	if len(p) == 0 {
+		return []any{slog.Int("len", 0)}
 	}
 
-	if d.debug {
-		fmt.Printf(color.HiGreenString("[DEBUG] ")+str, v...)
+	attrs := []any{slog.String("packet", PacketTypeName(p[0]))}
+	if p[0] == PACKET_MODIFYKEY && len(p) > 3 && p[1] != 0x03 {
+		attrs = append(attrs, slog.String("table", ModifyTableName(p[1])), slog.Int("row", int(p[3])))
 	}
+
+	return append(attrs, slog.String("data", fmt.Sprintf("%x", p)))
 }
 
 // #region Packet senders
-func (d *DrunkDeerController) SendIdentity() {
+func (d *DrunkDeerController) SendIdentity() error {
 	report := BuildIdentity()
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendLEDModeSelect(direction, sequence, speed, brightness, rgb byte) {
+func (d *DrunkDeerController) SendLEDModeSelect(direction, sequence, speed, brightness, rgb byte) error {
 	report := BuildLEDModeSelect(direction, sequence, speed, brightness, rgb)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendLEDModeSelectTurbo(direction, sequence, speed, brightness, rgb byte) {
+func (d *DrunkDeerController) SendLEDModeSelectTurbo(direction, sequence, speed, brightness, rgb byte) error {
 	report := BuildLEDModeSelectTurbo(direction, sequence, speed, brightness, rgb)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendModifyRow(row uint8, keys []byte) {
+func (d *DrunkDeerController) SendModifyRow(row uint8, keys []byte) error {
 	report := BuildModifyRowActuation(row, keys)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendRapidTriggerTurbo(rt, turbo bool) {
+func (d *DrunkDeerController) SendRapidTriggerTurbo(rt, turbo bool) error {
 	report := BuildRapidTriggerTurbo(rt, turbo)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendDownstrokes(row uint8, keys []byte) {
+func (d *DrunkDeerController) SendDownstrokes(row uint8, keys []byte) error {
 	report := BuildModifyRowDownstroke(row, keys)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) SendUpstrokes(row uint8, keys []byte) {
+func (d *DrunkDeerController) SendUpstrokes(row uint8, keys []byte) error {
 	report := BuildModifyRowUpstroke(row, keys)
-	d.QueuePacket(report)
+	return d.QueuePacket(report)
 }
 
-func (d *DrunkDeerController) QueuePacket(p []byte) {
-	d.packetQueue <- p
+// QueuePacket never blocks, it fails with ErrQueueFull when the device can't keep up. Use Flush to wait
+// for queued packets to be written.
+func (d *DrunkDeerController) QueuePacket(p []byte) error {
+	if d.shouldClose {
+		return fmt.Errorf("controller closed")
+	}
+
+	select {
+	case <-d.disconnected:
+		return d.disconnectErr
+	default:
+	}
+
+	// Counted before it's queued, the reporter could otherwise write it and go below zero first
+	d.pending.Add(1)
+	added, err := d.scheduler.push(p)
+	if !added {
+		d.pending.Add(-1)
+	}
+
+	return err
 }
 
 // #endregion
 
-func (d *DrunkDeerController) LoadActuations(actuations []byte) {
+func (d *DrunkDeerController) LoadActuations(actuations []byte) error {
 	if len(actuations) != len(KEYBOARD_LAYOUT) {
 		panic("Actuations length does not match keyboard layout length")
 	}
@@ -136,11 +278,15 @@ func (d *DrunkDeerController) LoadActuations(actuations []byte) {
 		}
 		row := d.actuations[i:end]
 
-		d.SendModifyRow(uint8(i/KEYS_PER_ROW), row)
+		if err := d.SendModifyRow(uint8(i/KEYS_PER_ROW), row); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (d *DrunkDeerController) LoadDownstrokes(downstrokes []byte) {
+func (d *DrunkDeerController) LoadDownstrokes(downstrokes []byte) error {
 	if len(downstrokes) != len(KEYBOARD_LAYOUT) {
 		panic("Downstrokes length does not match keyboard layout length")
 	}
@@ -155,11 +301,15 @@ func (d *DrunkDeerController) LoadDownstrokes(downstrokes []byte) {
 
 		row := d.downstrokes[i:end]
 		rowIndex := uint8(i / KEYS_PER_ROW)
-		d.SendDownstrokes(rowIndex, row)
+		if err := d.SendDownstrokes(rowIndex, row); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (d *DrunkDeerController) LoadUpstrokes(upstrokes []byte) {
+func (d *DrunkDeerController) LoadUpstrokes(upstrokes []byte) error {
 	if len(upstrokes) != len(KEYBOARD_LAYOUT) {
 		panic("Upstrokes length does not match keyboard layout length")
 	}
@@ -174,15 +324,18 @@ func (d *DrunkDeerController) LoadUpstrokes(upstrokes []byte) {
 
 		row := d.upstrokes[i:end]
 		rowIndex := uint8(i / KEYS_PER_ROW)
-		d.SendUpstrokes(rowIndex, row)
+		if err := d.SendUpstrokes(rowIndex, row); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 // #region Modifiers
 func (d *DrunkDeerController) ModifyActuationsByNames(names []string, actuations byte) {
 	for _, name := range names {
-		index := GetIndexByKey(name)
-		if index != -1 {
+		if index, err := ResolveKey(name); err == nil {
 			d.actuations[index] = actuations
 		}
 	}
@@ -204,15 +357,14 @@ func (d *DrunkDeerController) ModifyAllActuations(actuation byte) {
 
 // #endregion
 
-func (d *DrunkDeerController) WriteDefaults() {
-	actuations := make([]byte, KEYS_PER_ROW)
-	for i := 0; i < KEYS_PER_ROW; i++ {
-		actuations[i] = DEFAULT_ACTUATION
-	}
+func (d *DrunkDeerController) WriteDefaults() error {
+	d.logger.Info("writing defaults")
+	d.Light = &DDLight{Direction: 0, Sequence: SEQUENCE_OFF, Speed: 5, Brightness: 9}
 
-	d.Log("Writing defaults")
-	d.SendLEDModeSelect(0, SEQUENCE_OFF, 5, 9, 0xff)
-	d.SendRapidTriggerTurbo(false, false)
+	errs := []error{
+		d.SendLEDModeSelect(d.Light.Direction, d.Light.Sequence, d.Light.Speed, d.Light.Brightness, 0xff),
+		d.SendRapidTriggerTurbo(false, false),
+	}
 
 	for i := 0; i < len(d.actuations); i += KEYS_PER_ROW {
 		end := i + KEYS_PER_ROW
@@ -221,17 +373,19 @@ func (d *DrunkDeerController) WriteDefaults() {
 		}
 		rowIndex := uint8(i / KEYS_PER_ROW)
 
-		row := d.actuations[i:end]
-		d.SendModifyRow(rowIndex, row)
-
-		downstrokes := d.downstrokes[i:end]
-		d.SendDownstrokes(rowIndex, downstrokes)
+		errs = append(errs,
+			d.SendModifyRow(rowIndex, d.actuations[i:end]),
+			d.SendDownstrokes(rowIndex, d.downstrokes[i:end]),
+			d.SendUpstrokes(rowIndex, d.upstrokes[i:end]),
+		)
+	}
 
-		upstrokes := d.upstrokes[i:end]
-		d.SendUpstrokes(rowIndex, upstrokes)
+	if err := errors.Join(errs...); err != nil {
+		return err
 	}
 
-	d.Log("Defaults written")
+	d.logger.Info("defaults written")
+	return nil
 }
 
 func (d *DrunkDeerController) Close() error {
@@ -249,21 +403,35 @@ func (d *DrunkDeerController) Close() error {
 		case <-done:
 			// All goroutines have finished.
 		case <-time.After(5 * time.Second):
+			// Closing the channels under a live reader would panic it
 			closeErr = fmt.Errorf("timeout waiting for goroutines to exit")
+			return
 		}
 
-		close(d.packetQueue)
 		close(d.packetChan)
 	})
 	return closeErr
 }
 
 func NewDrunkDeerController(device *hid.Device) *DrunkDeerController {
+	return NewDrunkDeerControllerWithLogger(device, nil)
+}
+
+// A nil logger discards everything
+func NewDrunkDeerControllerWithLogger(device *hid.Device, logger *slog.Logger) *DrunkDeerController {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
 	controller := &DrunkDeerController{
-		device:      device,
-		packetChan:  make(chan DDPacket),
-		packetQueue: make(chan []byte, 10),
-		Light:       &DDLight{},
+		device:     device,
+		logger:     logger,
+		packetChan: make(chan DDPacket),
+		scheduler:  newPacketScheduler(),
+		Light:      &DDLight{},
+
+		disconnected: make(chan struct{}),
+		subscribers:  make(map[int]chan DDPacket),
 	}
 
 	controller.actuations = make([]byte, len(KEYBOARD_LAYOUT))
@@ -276,10 +444,11 @@ func NewDrunkDeerController(device *hid.Device) *DrunkDeerController {
 		controller.upstrokes[i] = 0x00
 	}
 
+	// The receiver isn't tracked by wg, it exits once Close closes packetChan
 	go controller.drunkDeerMessageReceiver()
-	controller.wg.Add(1)
 
 	// Start a goroutine to read from the device and send packets to the channel
+	controller.wg.Add(1)
 	go func() {
 		defer controller.wg.Done()
 		for {
@@ -288,31 +457,34 @@ func NewDrunkDeerController(device *hid.Device) *DrunkDeerController {
 			}
 
 			buf := make([]byte, 64)
-			n, err := device.Read(buf)
+			// Time out so shouldClose gets checked even if the keyboard stays quiet
+			n, err := device.ReadWithTimeout(buf, 100*time.Millisecond)
+			if err == hid.ErrTimeout {
+				continue
+			}
 			if err != nil {
+				controller.markDisconnected(err)
 				return // Exit if reading fails
 			}
 
-			if buf[0] != KEYBOARD_REPORT_ID {
+			// Need at least the report ID and packet type
+			if n < 2 || buf[0] != KEYBOARD_REPORT_ID {
 				continue
 			}
 
-			if n > 0 {
-				packet := DDPacket{
-					Packet: buf[1],
-					Data:   buf[2:n],
-				}
-				select {
-				case controller.packetChan <- packet:
-				case <-time.After(100 * time.Millisecond): // Prevent blocking
-				}
+			packet := DDPacket{
+				Packet: buf[1],
+				Data:   buf[2:n],
+			}
+			select {
+			case controller.packetChan <- packet:
+			case <-time.After(100 * time.Millisecond): // Prevent blocking
 			}
 		}
 	}()
-	controller.wg.Add(1)
 
-	go controller.drunkDeerReporter()
 	controller.wg.Add(1)
+	go controller.drunkDeerReporter()
 
 	return controller
 }
@@ -320,80 +492,93 @@ func NewDrunkDeerController(device *hid.Device) *DrunkDeerController {
 func (d *DrunkDeerController) drunkDeerReporter() {
 	defer d.wg.Done()
 	for {
-		select {
-		case p, ok := <-d.packetQueue:
-			if !ok {
-				return // Exit if channel is closed
+		if d.shouldClose {
+			return
+		}
+
+		p, ok := d.scheduler.pop()
+		if !ok {
+			select {
+			case <-d.scheduler.ready:
+			case <-time.After(100 * time.Millisecond): // Check shouldClose periodically
 			}
-			d.sendReport(p)
-			time.Sleep(100 * time.Millisecond) // Add a small delay to avoid overwhelming the device
-		case <-time.After(100 * time.Millisecond): // Check shouldClose periodically
-			if d.shouldClose {
-				return
+			continue
+		}
+
+		select {
+		case <-d.disconnected:
+			// Nothing to write to anymore, just drain
+		default:
+			if d.sendReport(p) == nil {
+				time.Sleep(100 * time.Millisecond) // Add a small delay to avoid overwhelming the device
 			}
 		}
+		d.pending.Add(-1)
 	}
 }
 
 func (d *DrunkDeerController) drunkDeerMessageReceiver() {
 	i := 0
-	defer d.wg.Done()
 	for p := range d.packetChan {
-		data := bytes.NewBuffer(p.Data)
-		expectedValue := data.Next(1)
 		i += 1
 
-		d.Log("%d Packet received: %x", i, p.Data)
-		switch p.Packet {
-		case PACKET_IDENTITY:
-			// fmt.Printf("Identity packet received: %x\n", p.Data)
-			theNullByte, _ := data.ReadByte()
-			data.ReadByte()
-			modelBytes := data.Next(3)
-
-			if theNullByte != 0x00 {
-				d.Log("Unknown byte 3: %x", theNullByte)
-				break
-			}
+		d.logger.Debug("packet received",
+			slog.Int("seq", i),
+			slog.String("packet", PacketTypeName(p.Packet)),
+			slog.String("data", fmt.Sprintf("%x", p.Data)))
+		d.publish(p)
 
-			if expectedValue[0] != 0x02 {
-				d.Log("Unknown expected value: %x", expectedValue[0])
-			}
+		if err := d.handlePacket(p); err != nil {
+			d.logger.Warn("failed to decode packet", slog.Int("seq", i), slog.Any("err", err))
+		}
+	}
+}
 
-			versionBytes := data.Next(2)
-			model, keyboardType := DetectKeyboardModel(modelBytes)
-			version := int(versionBytes[0] | versionBytes[1]<<8)
-			ident := DDKeyboardIdentity{
-				KeyboardModel:   model,
-				KeyboardType:    uint8(keyboardType),
-				FirmwareVersion: fmt.Sprintf("0.0%v", version),
-				RapidTrigger:    p.Data[15] != 0,
-				Turbo:           p.Data[14] != 0,
-			}
-			d.identity = &ident
-			break
-		case PACKET_LEDMODESEL:
-			d.Light.Direction = p.Data[2]
-			d.Light.Sequence = p.Data[3]
-			d.Light.Speed = p.Data[4]
-			d.Light.Brightness = p.Data[5]
-
-			break
-		case PACKET_TURBORT:
-			d.Log("Turbo packet received: %x", p.Data)
-			d.turbo = p.Data[6] != 0
-			d.rapidTrigger = p.Data[7] != 0
-			break
-		case PACKET_MODIFYKEY:
-			d.Log("Modify key packet received: %x", p.Data)
-			break
-		case PACKET_KEYTRACKING:
-
-			break
-		default:
-			d.Log("Unknown packet type: %x", p.Packet)
-			d.Log("Data: %x", p.Data)
-			break
+// Anything coming from the device is untrusted, knock-offs and buggy firmware send short reports
+func (d *DrunkDeerController) handlePacket(p DDPacket) error {
+	switch p.Packet {
+	case PACKET_IDENTITY:
+		ident, err := ParseIdentity(p.Data)
+		if err != nil {
+			return err
+		}
+
+		if p.Data[0] != 0x02 {
+			d.logger.Warn("unexpected identity value", slog.String("value", fmt.Sprintf("%x", p.Data[0])))
+		}
+
+		d.identity = ident
+		d.logger.Info("identity received",
+			slog.String("model", ident.KeyboardModel),
+			slog.String("firmware", ident.FirmwareVersion))
+	case PACKET_LEDMODESEL:
+		light, err := ParseLEDMode(p.Data)
+		if err != nil {
+			return err
 		}
+
+		d.Light = light
+	case PACKET_TURBORT:
+		turbo, rt, err := ParseTurboRT(p.Data)
+		if err != nil {
+			return err
+		}
+
+		d.turbo = turbo
+		d.rapidTrigger = rt
+	case PACKET_MODIFYKEY:
+		if _, err := ParseModifyKey(p.Data); err != nil {
+			return err
+		}
+	case PACKET_KEYTRACKING:
+		if _, err := ParseKeyTracking(p.Data); err != nil {
+			return err
+		}
+	default:
+		d.logger.Warn("unknown packet type",
+			slog.String("packet", fmt.Sprintf("%x", p.Packet)),
+			slog.String("data", fmt.Sprintf("%x", p.Data)))
 	}
+
+	return nil
 }
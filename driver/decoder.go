@@ -0,0 +1,157 @@
+package driver
+
+import "fmt"
+
+// Minimum payload lengths (after the report ID and packet type) for each inbound packet
+const (
+	identityPacketLen    = 16
+	ledModePacketLen     = 6
+	turboRTPacketLen     = 8
+	modifyKeyPacketLen   = 3
+	keyTrackingPacketLen = 3
+)
+
+type DecodeError struct {
+	Packet byte
+	Need   int
+	Got    int
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("malformed %s packet: %s", PacketTypeName(e.Packet), e.Reason)
+	}
+	return fmt.Sprintf("short %s packet: need %d bytes, got %d", PacketTypeName(e.Packet), e.Need, e.Got)
+}
+
+func checkLen(packet byte, data []byte, need int) error {
+	if len(data) < need {
+		return &DecodeError{Packet: packet, Need: need, Got: len(data)}
+	}
+	return nil
+}
+
+func ParseIdentity(data []byte) (*DDKeyboardIdentity, error) {
+	if err := checkLen(PACKET_IDENTITY, data, identityPacketLen); err != nil {
+		return nil, err
+	}
+
+	if data[1] != 0x00 {
+		return nil, &DecodeError{Packet: PACKET_IDENTITY, Reason: fmt.Sprintf("unknown byte 3: %x", data[1])}
+	}
+
+	_, _, known := LookupKeyboardModel(data[3:6])
+	model, keyboardType := DetectKeyboardModel(data[3:6])
+	version := int(data[6]) | int(data[7])<<8
+
+	return &DDKeyboardIdentity{
+		KeyboardModel:   model,
+		KeyboardType:    uint8(keyboardType),
+		ModelID:         append([]byte(nil), data[3:6]...),
+		UnknownModel:    !known,
+		FirmwareVersion: fmt.Sprintf("0.0%v", version),
+		RapidTrigger:    data[15] != 0,
+		Turbo:           data[14] != 0,
+	}, nil
+}
+
+func ParseLEDMode(data []byte) (*DDLight, error) {
+	if err := checkLen(PACKET_LEDMODESEL, data, ledModePacketLen); err != nil {
+		return nil, err
+	}
+
+	return &DDLight{
+		Direction:  data[2],
+		Sequence:   data[3],
+		Speed:      data[4],
+		Brightness: data[5],
+	}, nil
+}
+
+// Returns turbo, rapid trigger
+func ParseTurboRT(data []byte) (bool, bool, error) {
+	if err := checkLen(PACKET_TURBORT, data, turboRTPacketLen); err != nil {
+		return false, false, err
+	}
+
+	return data[6] != 0, data[7] != 0, nil
+}
+
+func ParseModifyKey(data []byte) (*DDModifyKeyEcho, error) {
+	if err := checkLen(PACKET_MODIFYKEY, data, modifyKeyPacketLen); err != nil {
+		return nil, err
+	}
+
+	return &DDModifyKeyEcho{
+		Table: data[0],
+		Row:   data[2],
+		Keys:  data[3:],
+	}, nil
+}
+
+func ParseKeyTracking(data []byte) (*DDKeyTracking, error) {
+	if err := checkLen(PACKET_KEYTRACKING, data, keyTrackingPacketLen); err != nil {
+		return nil, err
+	}
+
+	row := data[2]
+	if int(row)*KEYS_PER_ROW >= len(KEYBOARD_LAYOUT) {
+		return nil, &DecodeError{Packet: PACKET_KEYTRACKING, Reason: fmt.Sprintf("row %d out of range", row)}
+	}
+
+	// The last row is shorter, drop the padding
+	depths := data[3:]
+	if keys := min(KEYS_PER_ROW, len(KEYBOARD_LAYOUT)-int(row)*KEYS_PER_ROW); len(depths) > keys {
+		depths = depths[:keys]
+	}
+
+	return &DDKeyTracking{
+		Row:    row,
+		Depths: depths,
+	}, nil
+}
+
+// DescribePacket is a best-effort human readable summary of an inbound packet, for protocol research
+func DescribePacket(p DDPacket) string {
+	var desc string
+	var err error
+
+	switch p.Packet {
+	case PACKET_IDENTITY:
+		var ident *DDKeyboardIdentity
+		if ident, err = ParseIdentity(p.Data); err == nil {
+			desc = fmt.Sprintf("identity model=%s firmware=%s turbo=%v rt=%v",
+				ident.KeyboardModel, ident.FirmwareVersion, ident.Turbo, ident.RapidTrigger)
+		}
+	case PACKET_LEDMODESEL:
+		var light *DDLight
+		if light, err = ParseLEDMode(p.Data); err == nil {
+			desc = fmt.Sprintf("ledmode direction=%d sequence=%d speed=%d brightness=%d",
+				light.Direction, light.Sequence, light.Speed, light.Brightness)
+		}
+	case PACKET_TURBORT:
+		var turbo, rt bool
+		if turbo, rt, err = ParseTurboRT(p.Data); err == nil {
+			desc = fmt.Sprintf("turbort turbo=%v rt=%v", turbo, rt)
+		}
+	case PACKET_MODIFYKEY:
+		var echo *DDModifyKeyEcho
+		if echo, err = ParseModifyKey(p.Data); err == nil {
+			desc = fmt.Sprintf("modifykey table=%s row=%d", ModifyTableName(echo.Table), echo.Row)
+		}
+	case PACKET_KEYTRACKING:
+		var tracking *DDKeyTracking
+		if tracking, err = ParseKeyTracking(p.Data); err == nil {
+			desc = fmt.Sprintf("keytracking row=%d keys=%d", tracking.Row, len(tracking.Depths))
+		}
+	default:
+		return PacketTypeName(p.Packet)
+	}
+
+	if err != nil {
+		return err.Error()
+	}
+
+	return desc
+}
@@ -0,0 +1,117 @@
+package driver
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func newTestController() *DrunkDeerController {
+	return &DrunkDeerController{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Light:       &DDLight{},
+		subscribers: make(map[int]chan DDPacket),
+	}
+}
+
+// A real A75 identity reply, without the report ID and packet type
+var identityReply = []byte{0x02, 0x00, 0x00, 0x0b, 0x01, 0x01, 0x17, 0x00, 0, 0, 0, 0, 0, 0, 0x01, 0x01}
+
+func seedPackets(f *testing.F) {
+	f.Add(byte(PACKET_IDENTITY), identityReply)
+	f.Add(byte(PACKET_LEDMODESEL), BuildLEDModeSelect(0, SEQUENCE_WAVE, 5, 9, 0xff)[1:])
+	f.Add(byte(PACKET_TURBORT), BuildRapidTriggerTurbo(true, true)[1:])
+	f.Add(byte(PACKET_MODIFYKEY), BuildModifyRowActuation(1, []byte{0x14})[1:])
+	f.Add(byte(PACKET_KEYTRACKING), BuildModifyRow(2, []byte{0x05}, 0)[1:])
+	f.Add(byte(0x00), []byte{})
+}
+
+func TestParseIdentity(t *testing.T) {
+	ident, err := ParseIdentity(identityReply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ident.KeyboardModel != KEYBOARD_A75 || ident.FirmwareVersion != "0.023" || !ident.Turbo || !ident.RapidTrigger {
+		t.Fatalf("unexpected identity: %+v", ident)
+	}
+
+	// Little endian, the high byte used to be shifted out before it was widened
+	newer := append([]byte(nil), identityReply...)
+	newer[7] = 0x01
+	if ident, _ := ParseIdentity(newer); ident == nil || ident.FirmwareVersion != "0.0279" {
+		t.Fatalf("expected firmware 0.0279, got %+v", ident)
+	}
+
+	var decodeErr *DecodeError
+	if _, err := ParseIdentity(identityReply[:15]); !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a DecodeError for a short packet, got %v", err)
+	}
+}
+
+func FuzzHandlePacket(f *testing.F) {
+	seedPackets(f)
+	f.Fuzz(func(t *testing.T, packet byte, data []byte) {
+		d := newTestController()
+		_ = d.handlePacket(DDPacket{Packet: packet, Data: data})
+		_ = DescribePacket(DDPacket{Packet: packet, Data: data})
+	})
+}
+
+func FuzzParseIdentity(f *testing.F) {
+	f.Add(identityReply)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ident, err := ParseIdentity(data)
+		if err == nil && len(data) < identityPacketLen {
+			t.Fatalf("accepted a %d byte identity packet", len(data))
+		}
+		if err != nil && ident != nil {
+			t.Fatal("returned an identity along with an error")
+		}
+	})
+}
+
+func FuzzParseLEDMode(f *testing.F) {
+	f.Add(BuildLEDModeSelect(0, SEQUENCE_WAVE, 5, 9, 0xff)[1:])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := ParseLEDMode(data); err == nil && len(data) < ledModePacketLen {
+			t.Fatalf("accepted a %d byte LED mode packet", len(data))
+		}
+	})
+}
+
+func FuzzParseTurboRT(f *testing.F) {
+	f.Add(BuildRapidTriggerTurbo(true, false)[1:])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, _, err := ParseTurboRT(data); err == nil && len(data) < turboRTPacketLen {
+			t.Fatalf("accepted a %d byte turbo/RT packet", len(data))
+		}
+	})
+}
+
+func FuzzParseModifyKey(f *testing.F) {
+	f.Add(BuildModifyRowDownstroke(0, []byte{0x02})[1:])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := ParseModifyKey(data); err == nil && len(data) < modifyKeyPacketLen {
+			t.Fatalf("accepted a %d byte modify key packet", len(data))
+		}
+	})
+}
+
+func FuzzParseKeyTracking(f *testing.F) {
+	f.Add(BuildModifyRow(0, []byte{0x10, 0x20}, 0)[1:])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tracking, err := ParseKeyTracking(data)
+		if err != nil {
+			return
+		}
+
+		if len(tracking.Depths) > KEYS_PER_ROW {
+			t.Fatalf("returned %d depths for a single row", len(tracking.Depths))
+		}
+		if int(tracking.Row)*KEYS_PER_ROW+len(tracking.Depths) > len(KEYBOARD_LAYOUT) {
+			t.Fatalf("row %d is outside the layout", tracking.Row)
+		}
+	})
+}
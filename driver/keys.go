@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Common names for the layout keys, all upper case. Keys are normalized before lookup (see normalizeKeyName).
+var keyAliases = map[string]string{
+	"ESCAPE": "ESC",
+
+	"`": "TILDE", "~": "TILDE", "GRAVE": "TILDE", "BACKTICK": "TILDE", "BACKQUOTE": "TILDE",
+	"-": "MINUS", "_": "MINUS", "DASH": "MINUS", "HYPHEN": "MINUS",
+	"=": "PLUS", "+": "PLUS", "EQUAL": "PLUS", "EQUALS": "PLUS",
+	"BACKSPACE": "BACK", "BKSP": "BACK", "BSPC": "BACK",
+
+	"[": "BRKTS_L", "{": "BRKTS_L", "LBRACKET": "BRKTS_L", "LEFTBRACKET": "BRKTS_L", "OPENBRACKET": "BRKTS_L",
+	"]": "BRKTS_R", "}": "BRKTS_R", "RBRACKET": "BRKTS_R", "RIGHTBRACKET": "BRKTS_R", "CLOSEBRACKET": "BRKTS_R",
+	"\\": "SLASH_K29", "|": "SLASH_K29", "BACKSLASH": "SLASH_K29", "PIPE": "SLASH_K29",
+
+	"CAPSLOCK": "CAPS", "CAPSLK": "CAPS",
+	";": "COLON", ":": "COLON", "SEMICOLON": "COLON",
+	"'": "QOTATN", "\"": "QOTATN", "QUOTE": "QOTATN", "APOSTROPHE": "QOTATN",
+	"ENTER": "RETURN", "RET": "RETURN",
+
+	"LSHIFT": "SHF_L", "LEFTSHIFT": "SHF_L", "SHIFT_L": "SHF_L", "SHIFTLEFT": "SHF_L",
+	"RSHIFT": "SHF_R", "RIGHTSHIFT": "SHF_R", "SHIFT_R": "SHF_R", "SHIFTRIGHT": "SHF_R",
+	"ISO": "EUR_K45", "INTLBACKSLASH": "EUR_K45", "NONUSBACKSLASH": "EUR_K45",
+	",": "COMMA", "<": "COMMA",
+	".": "PERIOD", ">": "PERIOD", "DOT": "PERIOD",
+	"/": "SLASH", "?": "SLASH", "FORWARDSLASH": "SLASH",
+
+	"LCTRL": "CTRL_L", "LEFTCTRL": "CTRL_L", "LCONTROL": "CTRL_L", "LEFTCONTROL": "CTRL_L", "CONTROLLEFT": "CTRL_L",
+	"RCTRL": "CTRL_R", "RIGHTCTRL": "CTRL_R", "RCONTROL": "CTRL_R", "RIGHTCONTROL": "CTRL_R", "CONTROLRIGHT": "CTRL_R",
+	"LALT": "ALT_L", "LEFTALT": "ALT_L", "ALTLEFT": "ALT_L", "OPTION": "ALT_L",
+	"RALT": "ALT_R", "RIGHTALT": "ALT_R", "ALTRIGHT": "ALT_R", "ALTGR": "ALT_R",
+	"WIN": "WIN_L", "LWIN": "WIN_L", "WINDOWS": "WIN_L", "SUPER": "WIN_L", "META": "WIN_L", "GUI": "WIN_L", "CMD": "WIN_L",
+	"FN": "FN1", "FUNCTION": "FN1",
+	"MENU": "APP", "CONTEXTMENU": "APP",
+	"SPACEBAR": "SPACE", " ": "SPACE",
+
+	"UP": "ARR_UP", "ARROWUP": "ARR_UP", "UPARROW": "ARR_UP",
+	"DOWN": "ARR_DW", "ARROWDOWN": "ARR_DW", "DOWNARROW": "ARR_DW", "ARR_DOWN": "ARR_DW",
+	"LEFT": "ARR_L", "ARROWLEFT": "ARR_L", "LEFTARROW": "ARR_L", "ARR_LEFT": "ARR_L",
+	"RIGHT": "ARR_R", "ARROWRIGHT": "ARR_R", "RIGHTARROW": "ARR_R", "ARR_RIGHT": "ARR_R",
+}
+
+// Names that could mean more than one key, resolving them is an error that lists the options
+var ambiguousKeys = map[string][]string{
+	"SHIFT":   {"SHF_L", "SHF_R"},
+	"CTRL":    {"CTRL_L", "CTRL_R"},
+	"CONTROL": {"CTRL_L", "CTRL_R"},
+	"ALT":     {"ALT_L", "ALT_R"},
+}
+
+type KeyError struct {
+	Key        string
+	Candidates []string // Keys it could have meant, only for ambiguous names
+}
+
+func (e *KeyError) Error() string {
+	if len(e.Candidates) > 0 {
+		return fmt.Sprintf("key %q is ambiguous, use one of %s", e.Key, strings.Join(e.Candidates, ", "))
+	}
+	return fmt.Sprintf("unknown key %q", e.Key)
+}
+
+func (e *KeyError) Ambiguous() bool {
+	return len(e.Candidates) > 0
+}
+
+// ResolveKey finds a key's index in KEYBOARD_LAYOUT by its layout name or a common alias, ignoring case.
+// Errors are always a *KeyError.
+func ResolveKey(name string) (int, error) {
+	if name != "" {
+		for i, key := range KEYBOARD_LAYOUT {
+			if key == name {
+				return i, nil
+			}
+		}
+	}
+
+	normalized := normalizeKeyName(name)
+	if normalized == "" {
+		return -1, &KeyError{Key: name}
+	}
+
+	for i, key := range KEYBOARD_LAYOUT {
+		if key != "" && key == normalized {
+			return i, nil
+		}
+	}
+
+	if canonical, ok := keyAliases[normalized]; ok {
+		return ResolveKey(canonical)
+	}
+
+	if candidates, ok := ambiguousKeys[normalized]; ok {
+		return -1, &KeyError{Key: name, Candidates: candidates}
+	}
+
+	return -1, &KeyError{Key: name}
+}
+
+// Upper case, and for words also without spaces and dashes so "Left Shift" and "left-shift" both work.
+// Single characters are kept as-is since they're aliases themselves ("-", " ").
+func normalizeKeyName(name string) string {
+	if len(name) <= 1 {
+		return strings.ToUpper(name)
+	}
+
+	name = strings.ToUpper(strings.TrimSpace(name))
+	return strings.NewReplacer(" ", "", "-", "").Replace(name)
+}
+
+// KeyAliases lists every alias for a layout key, sorted
+func KeyAliases(key string) []string {
+	aliases := make([]string, 0)
+	for alias, canonical := range keyAliases {
+		if canonical == key {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases
+}
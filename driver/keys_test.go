@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveKey(t *testing.T) {
+	for name, want := range map[string]string{
+		"W":          "W",
+		"w":          "W",
+		"LSHIFT":     "SHF_L",
+		"Left Shift": "SHF_L",
+		"'":          "QOTATN",
+		"[":          "BRKTS_L",
+		";":          "COLON",
+		"`":          "TILDE",
+		"Backspace":  "BACK",
+		"\\":         "SLASH_K29",
+		"slash_k29":  "SLASH_K29",
+	} {
+		i, err := ResolveKey(name)
+		if err != nil {
+			t.Errorf("%q: %v", name, err)
+			continue
+		}
+		if KEYBOARD_LAYOUT[i] != want {
+			t.Errorf("%q resolved to %s, expected %s", name, KEYBOARD_LAYOUT[i], want)
+		}
+	}
+
+	var keyErr *KeyError
+	if _, err := ResolveKey("shift"); !errors.As(err, &keyErr) || !keyErr.Ambiguous() {
+		t.Errorf("expected shift to be ambiguous, got %v", err)
+	}
+
+	for _, name := range []string{"", "NOPE", "SHF-L"} {
+		if _, err := ResolveKey(name); !errors.As(err, &keyErr) || keyErr.Ambiguous() {
+			t.Errorf("expected %q to be unknown, got %v", name, err)
+		}
+	}
+}
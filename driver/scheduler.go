@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Enough for a full profile load (2 + 3 tables * 3 rows) with room to spare
+const maxQueuedPackets = 32
+
+var ErrQueueFull = errors.New("packet queue is full")
+
+type PacketPriority int
+
+const (
+	PriorityHigh   PacketPriority = iota // Identity, anything the caller is waiting on
+	PriorityNormal                       // Modes and toggles
+	PriorityBulk                         // Key tables
+
+	priorityCount
+)
+
+type queuedPacket struct {
+	data []byte
+	key  string
+}
+
+// Outbound packets, highest priority first and FIFO within a priority. A packet that supersedes a
+// queued one (same row of the same table, another LED mode, ...) replaces it in place.
+type packetScheduler struct {
+	mu     sync.Mutex
+	queues [priorityCount][]*queuedPacket
+	byKey  map[string]*queuedPacket
+	count  int
+	ready  chan struct{}
+}
+
+func newPacketScheduler() *packetScheduler {
+	return &packetScheduler{
+		byKey: make(map[string]*queuedPacket),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// ClassifyPacket returns the priority of an outbound packet and the key of the packets it supersedes,
+// an empty key means it never coalesces
+func ClassifyPacket(p []byte) (PacketPriority, string) {
+	if len(p) == 0 {
+		return PriorityNormal, ""
+	}
+
+	switch p[0] {
+	case PACKET_IDENTITY:
+		return PriorityHigh, "identity"
+	case PACKET_LEDMODESEL:
+		// The plain and turbo LED modes (byte 2) are separate settings
+		if len(p) > 2 {
+			return PriorityNormal, fmt.Sprintf("ledmode:%d", p[2])
+		}
+		return PriorityNormal, "ledmode"
+	case PACKET_TURBORT:
+		return PriorityNormal, "turbort"
+	case PACKET_MODIFYKEY:
+		if len(p) > 3 && p[1] != 0x03 {
+			return PriorityBulk, fmt.Sprintf("modifykey:%d:%d", p[1], p[3])
+		}
+		return PriorityNormal, "tracking"
+	}
+
+	return PriorityNormal, ""
+}
+
+// Returns whether the packet was added (false means it replaced a queued one)
+func (s *packetScheduler) push(p []byte) (bool, error) {
+	priority, key := ClassifyPacket(p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key != "" {
+		if queued, ok := s.byKey[key]; ok {
+			queued.data = p
+			return false, nil
+		}
+	}
+
+	if s.count >= maxQueuedPackets {
+		return false, ErrQueueFull
+	}
+
+	queued := &queuedPacket{data: p, key: key}
+	s.queues[priority] = append(s.queues[priority], queued)
+	if key != "" {
+		s.byKey[key] = queued
+	}
+	s.count++
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+
+	return true, nil
+}
+
+func (s *packetScheduler) pop() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for priority := range s.queues {
+		queue := s.queues[priority]
+		if len(queue) == 0 {
+			continue
+		}
+
+		queued := queue[0]
+		queue[0] = nil
+		s.queues[priority] = queue[1:]
+		if queued.key != "" {
+			delete(s.byKey, queued.key)
+		}
+		s.count--
+
+		return queued.data, true
+	}
+
+	return nil, false
+}
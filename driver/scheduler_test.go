@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSchedulerPriorityAndCoalescing(t *testing.T) {
+	s := newPacketScheduler()
+
+	rows := [][]byte{
+		BuildModifyRowActuation(0, nil),
+		BuildModifyRowActuation(1, nil),
+		BuildModifyRowDownstroke(0, nil),
+	}
+	for _, row := range rows {
+		if _, err := s.push(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newer := BuildModifyRowActuation(0, []byte{0x02})
+	if added, _ := s.push(newer); added {
+		t.Fatal("a newer actuation row 0 should replace the queued one")
+	}
+
+	s.push(BuildIdentity())
+
+	want := [][]byte{BuildIdentity(), newer, rows[1], rows[2]}
+	for i, w := range want {
+		got, ok := s.pop()
+		if !ok || !bytes.Equal(got, w) {
+			t.Fatalf("packet %d: got %x, want %x", i, got, w)
+		}
+	}
+
+	if _, ok := s.pop(); ok {
+		t.Fatal("scheduler should be empty")
+	}
+}
+
+func TestSchedulerBackpressure(t *testing.T) {
+	s := newPacketScheduler()
+
+	s.push(BuildLEDModeSelect(0, SEQUENCE_OFF, 5, 9, 0xff))
+	for i := 1; i < maxQueuedPackets; i++ {
+		if _, err := s.push([]byte{0xEE, byte(i)}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+
+	if _, err := s.push([]byte{0xEE}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// Superseding a queued packet doesn't need room
+	if _, err := s.push(BuildLEDModeSelect(0, SEQUENCE_WAVE, 5, 9, 0xff)); err != nil {
+		t.Fatalf("expected the LED mode to be replaced, got %v", err)
+	}
+}
+
+func TestClassifyPacketLEDModes(t *testing.T) {
+	_, plain := ClassifyPacket(BuildLEDModeSelect(0, SEQUENCE_WAVE, 5, 9, 0xff))
+	_, turbo := ClassifyPacket(BuildLEDModeSelectTurbo(0, SEQUENCE_WAVE, 5, 9, 0xff))
+	if plain == turbo {
+		t.Fatalf("plain and turbo LED modes share the key %q", plain)
+	}
+
+	s := newPacketScheduler()
+	s.push(BuildLEDModeSelect(0, SEQUENCE_WAVE, 5, 9, 0xff))
+	if added, _ := s.push(BuildLEDModeSelectTurbo(0, SEQUENCE_OFF, 5, 9, 0xff)); !added {
+		t.Fatal("the turbo LED mode shouldn't replace the plain one")
+	}
+}
@@ -15,8 +15,23 @@ type DDLight struct {
 type DDKeyboardIdentity struct {
 	KeyboardModel string
 	KeyboardType  uint8
+	ModelID       []byte // Raw model bytes from the identity packet
+	UnknownModel  bool   // ModelID isn't in the table, KeyboardModel is a guess
 
 	FirmwareVersion string
 	Turbo           bool
 	RapidTrigger    bool
 }
+
+// Echo of a PACKET_MODIFYKEY report
+type DDModifyKeyEcho struct {
+	Table byte
+	Row   uint8
+	Keys  []byte
+}
+
+// One row of key travel, same layout as the rows sent with PACKET_MODIFYKEY
+type DDKeyTracking struct {
+	Row    uint8
+	Depths []byte // 0.1mm units
+}
@@ -1,6 +1,9 @@
 package driver
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+)
 
 func BoolToByte(b bool) byte {
 	if b {
@@ -10,6 +13,16 @@ func BoolToByte(b bool) byte {
 }
 
 func DetectKeyboardModel(modelBytes []byte) (string, int) { // Model and type
+	model, keyboardType, ok := LookupKeyboardModel(modelBytes)
+	if !ok {
+		// Default to A75 if no match found
+		return KEYBOARD_A75, 75
+	}
+	return model, keyboardType
+}
+
+// Like DetectKeyboardModel but reports whether the model bytes are known instead of guessing
+func LookupKeyboardModel(modelBytes []byte) (string, int, bool) {
 	A75 := [][]byte{
 		{0x0b, 0x01, 0x01},
 		{0x0b, 0x04, 0x01},
@@ -34,36 +47,66 @@ func DetectKeyboardModel(modelBytes []byte) (string, int) { // Model and type
 
 	for _, modelA75 := range A75 {
 		if bytes.Equal(modelA75, modelBytes) {
-			return KEYBOARD_A75, 75
+			return KEYBOARD_A75, 75, true
 		}
 	}
 
 	for _, modelA75Pro := range A75Pro {
 		if bytes.Equal(modelA75Pro, modelBytes) {
-			return KEYBOARD_A75PRO, 750
+			return KEYBOARD_A75PRO, 750, true
 		}
 	}
 
 	for _, modelG75 := range G75 {
 		if bytes.Equal(modelG75, modelBytes) {
-			return KEYBOARD_G75, 754
+			return KEYBOARD_G75, 754, true
 		}
 	}
 
 	for _, modelG65 := range G65 {
 		if bytes.Equal(modelG65, modelBytes) {
-			return KEYBOARD_G65, 65
+			return KEYBOARD_G65, 65, true
 		}
 	}
 
 	for _, modelG60 := range G60 {
 		if bytes.Equal(modelG60, modelBytes) {
-			return KEYBOARD_G60, 60
+			return KEYBOARD_G60, 60, true
 		}
 	}
 
-	// Default to A75 if no match found
-	return KEYBOARD_A75, 75
+	return "", 0, false
+}
+
+func PacketTypeName(packet byte) string {
+	switch packet {
+	case PACKET_IDENTITY:
+		return "identity"
+	case PACKET_LEDMODESEL:
+		return "ledmode"
+	case PACKET_MODIFYKEY:
+		return "modifykey"
+	case PACKET_TURBORT:
+		return "turbort"
+	case PACKET_KEYTRACKING:
+		return "keytracking"
+	}
+	return fmt.Sprintf("0x%02x", packet)
+}
+
+// Second byte of a PACKET_MODIFYKEY report
+func ModifyTableName(table byte) string {
+	switch table {
+	case 0x01:
+		return "actuation"
+	case 0x03:
+		return "tracking"
+	case 0x04:
+		return "downstroke"
+	case 0x05:
+		return "upstroke"
+	}
+	return fmt.Sprintf("0x%02x", table)
 }
 
 func GetKeyByIndex(index int) string {
@@ -73,15 +116,6 @@ func GetKeyByIndex(index int) string {
 	return ""
 }
 
-func GetIndexByKey(key string) int {
-	for i, v := range KEYBOARD_LAYOUT {
-		if v == key {
-			return i
-		}
-	}
-	return -1
-}
-
 func GetRowByIndex(index int) int {
 	if index >= 0 && index < len(KEYBOARD_LAYOUT) {
 		return index / KEYS_PER_ROW
@@ -6,15 +6,18 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
 	"github.com/fatih/color"
 )
 
 func (a *App) handleArgs() {
-	if a.args.Debug {
-		debug = true
-		debugPrintf("DEBUG ENABLED")
+	a.keyboardIndex = a.args.Index
+	if a.keyboardIndex < 0 {
+		a.keyboardIndex = 0
 	}
 
 	switch a.args.Command {
@@ -26,12 +29,30 @@ func (a *App) handleArgs() {
 		a.args.Reset = true
 	case "import":
 		a.args.Import = a.args.CmdValue
+	case "export":
+		a.exportConfig(a.args.CmdValue)
 	case "version":
 		a.showVersion()
 	case "list":
 		displayDeviceList()
 	case "profiles":
 		a.displayProfiles()
+	case "daemon":
+		a.runDaemon()
+		os.Exit(0)
+	case "serve":
+		a.runServe()
+		os.Exit(0)
+	case "validate":
+		a.handleValidate(a.args.CmdValue)
+	case "keys":
+		displayKeys()
+	case "show":
+		a.handleShow(a.args.CmdValue)
+	case "convert":
+		a.handleConvert(a.args.CmdValue)
+	case "migrate":
+		a.handleMigrate(a.args.CmdValue)
 	}
 
 	switch {
@@ -46,11 +67,6 @@ func (a *App) handleArgs() {
 	case a.args.Save != "":
 		a.saveConfig(a.args.Save)
 	}
-
-	a.keyboardIndex = a.args.Index
-	if a.keyboardIndex < 0 {
-		a.keyboardIndex = 0
-	}
 }
 
 func (a *App) showVersion() {
@@ -59,7 +75,7 @@ func (a *App) showVersion() {
 }
 
 func (a *App) saveConfig(profilePath string) {
-	if isURL(profilePath) {
+	if keyboard.IsURL(profilePath) {
 		a.saveConfigFromURL(profilePath)
 		return
 	}
@@ -69,7 +85,7 @@ func (a *App) saveConfig(profilePath string) {
 		os.Exit(1)
 	}
 
-	fileName := ensureJSONExtension(filepath.Base(profilePath))
+	fileName := ensureProfileExtension(filepath.Base(profilePath))
 	targetPath := filepath.Join(a.profilePath, fileName)
 
 	if _, err := os.Stat(targetPath); err == nil {
@@ -87,18 +103,14 @@ func (a *App) saveConfig(profilePath string) {
 }
 
 func (a *App) saveConfigFromURL(url string) {
-	data, err := download(url)
+	data, err := keyboard.Download(url)
 	if err != nil {
 		color.HiRed("Error downloading profile: %v\n", err)
 		os.Exit(1)
 	}
 
-	fileName := ensureJSONExtension(
-		strings.TrimSuffix(
-			filepath.Base(url),
-			filepath.Ext(url),
-		),
-	)
+	// Named after the URL, in whatever format the server sent
+	fileName := strings.TrimSuffix(filepath.Base(url), filepath.Ext(url)) + keyboard.DetectFormat(url, data).Extension()
 	targetPath := filepath.Join(a.profilePath, fileName)
 
 	file, err := os.Create(targetPath)
@@ -121,8 +133,8 @@ func (a *App) importConfig(source string) {
 	var data []byte
 	var err error
 
-	if isURL(source) {
-		data, err = download(source)
+	if keyboard.IsURL(source) {
+		data, err = keyboard.Download(source)
 	} else {
 		data, err = os.ReadFile(source)
 	}
@@ -132,28 +144,105 @@ func (a *App) importConfig(source string) {
 		os.Exit(1)
 	}
 
-	config := parseDrunkDeerConfig(data).convertToCLIConfig()
-	fileName := ensureJSONExtension(filepath.Base(source))
-	targetPath := filepath.Join(a.profilePath, fileName)
+	// Vendor exports are JSON, but one converted to YAML or with comments added reads just as well
+	data, err = keyboard.ToJSON(data, keyboard.DetectFormat(source, data))
+	handleError("Error loading profile", err)
 
-	if err := a.writeConfigToFile(config, targetPath); err != nil {
-		color.HiRed("Error saving imported profile: %v\n", err)
-		os.Exit(1)
+	ddConfigs, err := parseDrunkDeerConfigs(data)
+	handleError("Error parsing JSON", err)
+
+	// A single export keeps the file's name, files with several profiles get one per profile named after it
+	names := []string{ensureProfileExtension(filepath.Base(source))}
+	if len(ddConfigs) > 1 {
+		names = antlerProfileNames(ddConfigs)
 	}
 
-	a.showImportSuccess(fileName, targetPath)
+	for i, ddConfig := range ddConfigs {
+		if _, err := ddConfig.modelFromStorageName(); err != nil {
+			color.HiYellow("Warning: %v, %s will load on any model", err, names[i])
+		}
+
+		targetPath := filepath.Join(a.profilePath, names[i])
+		if err := a.writeConfigToFile(ddConfig.convertToCLIConfig(), targetPath); err != nil {
+			color.HiRed("Error saving imported profile: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(ddConfigs) > 1 {
+			color.HiGreen("Imported %s", targetPath)
+		}
+	}
+
+	if len(ddConfigs) == 1 {
+		a.showImportSuccess(names[0], filepath.Join(a.profilePath, names[0]))
+	}
+	os.Exit(0)
 }
 
-func (a *App) writeConfigToFile(config *Config, path string) error {
-	jsonData, err := json.Marshal(config)
+// Prints the profile, with everything it extends resolved, in another program's format
+func (a *App) exportConfig(profile string) {
+	if profile == "" {
+		handleError("Error", fmt.Errorf("usage: drunkdeer export <profile> --format antler"))
+	}
+	if a.args.Format != "antler" {
+		handleError("Error", fmt.Errorf("unknown export format %q, only antler is supported", a.args.Format))
+	}
+
+	config, err := a.loadConfig(profile)
+	handleError("Failed to load profile", err)
+
+	model := config.Model
+	if a.args.Model != "" {
+		model, err = parseAntlerModel(a.args.Model)
+		handleError("Error exporting profile", err)
+
+		if config.Model != "" && config.Model != model {
+			handleError("Error exporting profile", fmt.Errorf("%s is a %s profile, not %s", profile, config.Model, model))
+		}
+	}
+	if model == "" {
+		model = a.connectedModel()
+	}
+	if model == "" {
+		handleError("Error exporting profile", fmt.Errorf("%s doesn't set a model, pass --model (A75, G75, G65 or G60) or connect the keyboard", profile))
+	}
+
+	name := keyboard.TrimProfileExtension(filepath.Base(profile))
+	ddConfig, err := newDDConfig(name, model, config)
+	handleError("Error exporting profile", err)
+
+	data, err := json.MarshalIndent(ddConfig, "", "    ")
+	handleError("Error exporting profile", err)
+
+	fmt.Println(string(data))
+	os.Exit(0)
+}
+
+// Written in the format the file extension asks for, JSON if it's not a profile extension. A profile that
+// extends another only gets the fields that differ from it.
+func (a *App) writeConfigToFile(config *keyboard.Profile, path string) error {
+	format, err := keyboard.ParseFormat(filepath.Ext(path))
+	if err != nil {
+		format = keyboard.FormatJSON
+	}
+
+	var data []byte
+	if config.Extends != "" {
+		var sparse []byte
+		if sparse, err = keyboard.SparseProfile(config, path); err == nil {
+			data, err = keyboard.ConvertProfile(sparse, keyboard.FormatJSON, format)
+		}
+	} else {
+		data, err = keyboard.MarshalProfile(config, format)
+	}
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, jsonData, 0644)
+	return os.WriteFile(path, data, 0644)
 }
 
 func (a *App) showImportSuccess(fileName, path string) {
-	profileName := strings.TrimSuffix(fileName, ".json")
+	profileName := keyboard.TrimProfileExtension(fileName)
 	color.HiGreen("Profile imported to %s\n", path)
 	fmt.Printf("Use: ")
 	color.HiBlue("drunkdeer load %s\n", profileName)
@@ -161,7 +250,7 @@ func (a *App) showImportSuccess(fileName, path string) {
 }
 
 func (a *App) displayProfiles() {
-	profiles, err := os.ReadDir(a.profilePath)
+	profiles, err := listProfiles(a.profilePath)
 	if err != nil {
 		color.HiRed("Error reading profile directory: %v", err)
 		os.Exit(1)
@@ -173,18 +262,32 @@ func (a *App) displayProfiles() {
 	}
 
 	color.HiGreen("Available profiles:")
-	for _, profile := range profiles {
-		if profile.IsDir() {
+	for _, profileName := range profiles {
+		color.White("%s", profileName)
+	}
+	os.Exit(0)
+}
+
+func displayKeys() {
+	color.HiGreen("Key names (case doesn't matter) and their aliases:")
+	for _, key := range driver.KEYBOARD_LAYOUT {
+		if key == "" {
 			continue
 		}
-		profileName := strings.TrimSuffix(profile.Name(), ".json")
-		color.White("%s", profileName)
+
+		aliases := make([]string, 0)
+		for _, alias := range driver.KeyAliases(key) {
+			aliases = append(aliases, strconv.Quote(alias))
+		}
+		fmt.Printf("%-10s %s\n", key, color.WhiteString(strings.Join(aliases, " ")))
 	}
+
+	color.HiGreen("\nGroups: %s", strings.Join(keyboard.BuiltinGroups(), " "))
 	os.Exit(0)
 }
 
 func displayDeviceList() {
-	devices := FindDrunkDeerDevices()
+	devices := keyboard.FindDrunkDeerDevices()
 	if len(devices) == 0 {
 		color.HiRed("No devices found")
 		os.Exit(0)
@@ -211,8 +314,9 @@ func displayDeviceList() {
 	os.Exit(0)
 }
 
-func ensureJSONExtension(filename string) string {
-	if !strings.HasSuffix(filename, ".json") {
+// Keeps .yaml, .toml etc, anything else gets .json
+func ensureProfileExtension(filename string) string {
+	if !keyboard.HasProfileExtension(filename) {
 		return filename + ".json"
 	}
 	return filename
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+const calibrationIdleTime = 3 * time.Second
+
+func (a *App) handleCalibrate() {
+	// Ctrl+C ends the pressing phase, it shouldn't kill the stream before we save
+	trackCtx, stopTracking := context.WithCancel(context.Background())
+	defer stopTracking()
+
+	travel, err := a.kb.TrackKeys(trackCtx)
+	handleError("Error starting key tracking", err)
+
+	calibrator := keyboard.NewCalibrator()
+
+	color.HiBlue("Keep your hands off the keyboard...")
+	idle := time.After(calibrationIdleTime)
+	samples := 0
+idleLoop:
+	for {
+		select {
+		case t, ok := <-travel:
+			if !ok {
+				handleError("Key tracking stopped", fmt.Errorf("device disconnected"))
+			}
+			calibrator.Idle(t)
+			samples++
+		case <-idle:
+			break idleLoop
+		case <-a.ctx.Done():
+			return
+		}
+	}
+
+	if samples == 0 {
+		handleError("Error calibrating", fmt.Errorf("the keyboard isn't sending key tracking data"))
+	}
+
+	color.HiBlue("Now fully press and release every key, one at a time")
+	color.White("Stops when every key was pressed, after %s, or on Ctrl+C", a.args.Duration)
+
+	timeout := time.After(a.args.Duration)
+	remaining := len(calibrator.Remaining())
+pressLoop:
+	for remaining > 0 {
+		select {
+		case t, ok := <-travel:
+			if !ok {
+				break pressLoop
+			}
+			calibrator.Press(t)
+
+			if left := len(calibrator.Remaining()); left != remaining {
+				remaining = left
+				fmt.Printf("\r%3d keys left", remaining)
+			}
+		case <-timeout:
+			break pressLoop
+		case <-a.ctx.Done():
+			break pressLoop
+		}
+	}
+	fmt.Println()
+
+	identity := a.kb.Identity()
+	calibration := calibrator.Result(a.serial, identity.KeyboardModel)
+	path := keyboard.CalibrationPath(a.profilePath, a.serial)
+	handleError("Error saving calibration", calibration.Save(path))
+
+	a.showCalibrationReport(calibration)
+	color.HiGreen("Calibration saved to %s", path)
+}
+
+func (a *App) showCalibrationReport(calibration *keyboard.Calibration) {
+	issues := calibration.Issues()
+	if len(issues) == 0 {
+		color.HiGreen("All %d sensors look healthy", len(calibration.Keys))
+		return
+	}
+
+	color.HiYellow("%d issues found:", len(issues))
+	for _, issue := range issues {
+		fmt.Printf("  %-10s %s\n", issue.Key, issue.Reason)
+	}
+}
+
+// Called after a load, calibrating is optional so a missing file is fine
+func (a *App) warnNoisyActuations(actuations []byte) {
+	calibration, err := keyboard.LoadCalibration(keyboard.CalibrationPath(a.profilePath, a.serial))
+	if err != nil {
+		return
+	}
+
+	if noisy := calibration.NoisyActuations(actuations); len(noisy) > 0 {
+		color.HiYellow("Warning: actuation is within the sensor noise of %s, these keys may fire on their own",
+			strings.Join(noisy, ", "))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+// Writes the profile next to the original in another format. Comments don't survive, everything else does.
+func (a *App) handleConvert(profilePath string) {
+	if profilePath == "" || a.args.To == "" {
+		handleError("Error", fmt.Errorf("usage: drunkdeer convert <profile> --to <json/jsonc/yaml/toml>"))
+	}
+
+	format, err := keyboard.ParseFormat(a.args.To)
+	handleError("Error", err)
+
+	source := profilePath
+	if !keyboard.IsURL(source) {
+		source = a.resolveProfilePath(source)
+	}
+
+	data, err := keyboard.ReadProfile(source)
+	handleError("Failed to read profile", err)
+
+	from := keyboard.DetectFormat(source, data)
+	_, err = keyboard.ParseProfileFormat(data, from)
+	handleError("Invalid profile", err)
+
+	converted, err := keyboard.ConvertProfile(data, from, format)
+	handleError("Error converting profile", err)
+
+	// Downloaded profiles end up in the profile directory
+	target := strings.TrimSuffix(source, filepath.Ext(source)) + format.Extension()
+	if keyboard.IsURL(source) {
+		name := keyboard.TrimProfileExtension(path.Base(strings.SplitN(source, "?", 2)[0]))
+		target = filepath.Join(a.profilePath, name+format.Extension())
+	}
+
+	if target == source {
+		handleError("Error", fmt.Errorf("%s is already %s", source, format))
+	}
+	if _, err := os.Stat(target); err == nil {
+		handleError("Error", fmt.Errorf("%s already exists", target))
+	}
+
+	handleError("Error writing profile", os.WriteFile(target, converted, 0644))
+	color.HiGreen("Converted %s (%s) to %s", source, from, target)
+	os.Exit(0)
+}
@@ -2,8 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
 )
 
+// The model sits right after this in vendor storage names, see modelFromStorageName
+const antlerStoragePrefix = "DDKB_"
+
 type DDConfigKey struct {
 	Keyname    string  `json:"keyname"`
 	Actuation  float32 `json:"action_point"`
@@ -15,72 +25,95 @@ type DDConfig struct {
 	StorageName string        `json:"storagename"`
 	Showname    string        `json:"showname"`
 	Keys        []DDConfigKey `json:"keys_array"`
+
+	// TODO: turbo, rapid trigger and lighting, once a real web driver export shows where (and if) they're stored
 }
 
 func (c *DDConfig) getMostUsedActuation() float32 {
-	var distinctActuations map[float32]int = make(map[float32]int)
-	for _, key := range c.Keys {
-		distinctActuations[key.Actuation]++
-	}
+	return mostUsed(c.Keys, func(key DDConfigKey) float32 { return key.Actuation })
+}
 
-	var mostUsedActuation float32 = 0
-	var mostUsedCount int = 0
-	for actuation, count := range distinctActuations {
-		if count > mostUsedCount {
-			mostUsedCount = count
-			mostUsedActuation = actuation
-		}
-	}
+func (c *DDConfig) getMostUsedDownstroke() float32 {
+	return mostUsed(c.Keys, func(key DDConfigKey) float32 { return key.Downstroke })
+}
 
-	return mostUsedActuation
+func (c *DDConfig) getMostUsedUpstroke() float32 {
+	return mostUsed(c.Keys, func(key DDConfigKey) float32 { return key.Upstroke })
 }
 
-func (c *DDConfig) getMostUsedDownstroke() float32 {
-	var distinctDownstrokes map[float32]int = make(map[float32]int)
-	for _, key := range c.Keys {
-		distinctDownstrokes[key.Downstroke]++
+// Ties go to the smaller value so importing the same file always gives the same profile
+func mostUsed(keys []DDConfigKey, value func(DDConfigKey) float32) float32 {
+	counts := make(map[float32]int)
+	for _, key := range keys {
+		counts[value(key)]++
 	}
 
-	var mostUsedDownstroke float32 = 0
+	var mostUsedValue float32 = 0
 	var mostUsedCount int = 0
-	for downstroke, count := range distinctDownstrokes {
-		if count > mostUsedCount {
+	for v, count := range counts {
+		if count > mostUsedCount || count == mostUsedCount && v < mostUsedValue {
 			mostUsedCount = count
-			mostUsedDownstroke = downstroke
+			mostUsedValue = v
 		}
 	}
 
-	return mostUsedDownstroke
+	return mostUsedValue
 }
 
-func (c *DDConfig) getMostUsedUpstroke() float32 {
-	var distinctUpstrokes map[float32]int = make(map[float32]int)
-	for _, key := range c.Keys {
-		distinctUpstrokes[key.Upstroke]++
+// Model names as they show up in vendor storage names. Longer names first so A75PRO isn't read as A75.
+var antlerModels = []struct {
+	name  string
+	model string
+}{
+	{"A75PRO", driver.KEYBOARD_A75PRO},
+	{"A75", driver.KEYBOARD_A75},
+	{"G75", driver.KEYBOARD_G75},
+	{"G65", driver.KEYBOARD_G65},
+	{"G60", driver.KEYBOARD_G60},
+}
+
+// Storage names look like DDKB_A75_name, but only a known model name counts, split out by _ or - so a profile
+// called "G60ish" on an A75 isn't taken for a G60
+func (c *DDConfig) modelFromStorageName() (string, error) {
+	parts := strings.FieldsFunc(strings.ToUpper(c.StorageName), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, known := range antlerModels {
+		for _, part := range parts {
+			if part == known.name {
+				return known.model, nil
+			}
+		}
 	}
 
-	var mostUsedUpstroke float32 = 0
-	var mostUsedCount int = 0
-	for upstroke, count := range distinctUpstrokes {
-		if count > mostUsedCount {
-			mostUsedCount = count
-			mostUsedUpstroke = upstroke
+	// Older names had the model at a fixed spot without separators
+	if len(c.StorageName) >= 8 {
+		legacy := strings.ToUpper(c.StorageName[5:8])
+		for _, known := range antlerModels {
+			if legacy == known.name {
+				return known.model, nil
+			}
 		}
 	}
 
-	return mostUsedUpstroke
+	return "", fmt.Errorf("no known keyboard model in storage name %q", c.StorageName)
 }
 
-func (c *DDConfig) getModelFromStorageName() string {
-	sub := c.StorageName[5:8]
-
-	return sub
+// Model as the driver names it, for a name like a75 or G65 given on the command line
+func parseAntlerModel(name string) (string, error) {
+	for _, known := range antlerModels {
+		if strings.EqualFold(name, known.name) {
+			return known.model, nil
+		}
+	}
+	return "", fmt.Errorf("unknown keyboard model %q, use A75, G75, G65 or G60", name)
 }
 
-func (c *DDConfig) convertToCLIConfig() *Config {
-	var config Config
+func (c *DDConfig) convertToCLIConfig() *keyboard.Profile {
+	var config keyboard.Profile
 
-	config.Model = c.getModelFromStorageName()
+	config.Model, _ = c.modelFromStorageName() // Empty if unknown, the profile then loads on any model
 	config.DefaultActuation = c.getMostUsedActuation()
 	config.RapidTrigger.DefaultDownstroke = c.getMostUsedDownstroke()
 	config.RapidTrigger.DefaultUpstroke = c.getMostUsedUpstroke()
@@ -91,7 +124,7 @@ func (c *DDConfig) convertToCLIConfig() *Config {
 	config.ActuationPoints = make(map[string]float32)
 	config.RapidTriggers = make(map[string][2]float32)
 
-	config.Light = LightSettings{}
+	config.Light = keyboard.LightSettings{}
 	config.Light.Enabled = false
 
 	for _, key := range c.Keys {
@@ -101,11 +134,12 @@ func (c *DDConfig) convertToCLIConfig() *Config {
 			config.ActuationPoints[key.Keyname] = key.Actuation
 		}
 
-		if key.Downstroke != 0 && key.Downstroke != config.RapidTrigger.DefaultDownstroke {
+		// 0 is a valid distance, it has to be kept when the default isn't 0
+		if key.Downstroke != config.RapidTrigger.DefaultDownstroke {
 			createRtEntry = true
 		}
 
-		if key.Upstroke != 0 && key.Upstroke != config.RapidTrigger.DefaultUpstroke {
+		if key.Upstroke != config.RapidTrigger.DefaultUpstroke {
 			createRtEntry = true
 		}
 
@@ -117,10 +151,145 @@ func (c *DDConfig) convertToCLIConfig() *Config {
 	return &config
 }
 
-func parseDrunkDeerConfig(config []byte) *DDConfig {
-	var ddConfig DDConfig
-	err := json.Unmarshal(config, &ddConfig)
-	handleError("Error parsing JSON", err)
+// One exported profile, or several in a list or wrapped in an object. No web driver backup has been checked, so
+// rather than guess its shape anything with a keys_array is a profile, wherever it is.
+func parseDrunkDeerConfigs(data []byte) ([]*DDConfig, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	configs := make([]*DDConfig, 0)
+	if err := collectDDConfigs(doc, &configs); err != nil {
+		return nil, err
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no profiles found, expected objects with a keys_array")
+	}
+
+	for _, config := range configs {
+		if len(config.Keys) == 0 {
+			return nil, fmt.Errorf("profile %q has no keys", config.Showname)
+		}
+	}
+	return configs, nil
+}
+
+func collectDDConfigs(v any, configs *[]*DDConfig) error {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if err := collectDDConfigs(item, configs); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if _, ok := v["keys_array"]; ok {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			var config DDConfig
+			if err := json.Unmarshal(data, &config); err != nil {
+				return fmt.Errorf("profile %v: %w", v["showname"], err)
+			}
+			*configs = append(*configs, &config)
+			return nil
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := collectDDConfigs(v[key], configs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Profile file names from the show names, made safe for a file name and unique
+func antlerProfileNames(configs []*DDConfig) []string {
+	names := make([]string, len(configs))
+	used := make(map[string]bool)
+
+	for i, config := range configs {
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+				return r
+			case unicode.IsSpace(r):
+				return '-'
+			}
+			return -1
+		}, strings.TrimSpace(config.Showname))
+		if name == "" {
+			name = fmt.Sprintf("antler-%d", i+1)
+		}
+
+		unique := name
+		for n := 2; used[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s-%d", name, n)
+		}
+		used[strings.ToLower(unique)] = true
+
+		names[i] = unique + ".json"
+	}
+
+	return names
+}
+
+// Builds the vendor config for a (resolved) profile, with an entry for every key on the layout since the web
+// driver has no notion of defaults. Only the per-key values are written.
+func newDDConfig(name, model string, profile *keyboard.Profile) (*DDConfig, error) {
+	// The web driver won't load a config for another model, guessing one would make it useless on the rest
+	if model == "" {
+		return nil, fmt.Errorf("no keyboard model to export for")
+	}
+
+	points, err := keyboard.ExpandSelectors(profile.ActuationPoints, profile.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	triggers, err := keyboard.ExpandSelectors(profile.RapidTriggers, profile.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &DDConfig{
+		StorageName: antlerStoragePrefix + model + "_" + name,
+		Showname:    name,
+		Keys:        make([]DDConfigKey, 0, len(driver.KEYBOARD_LAYOUT)),
+	}
+
+	for i, keyname := range driver.KEYBOARD_LAYOUT {
+		if keyname == "" {
+			continue
+		}
+
+		key := DDConfigKey{
+			Keyname:    keyname,
+			Actuation:  profile.DefaultActuation,
+			Downstroke: profile.RapidTrigger.DefaultDownstroke,
+			Upstroke:   profile.RapidTrigger.DefaultUpstroke,
+		}
+		if actuation, ok := points[i]; ok {
+			key.Actuation = actuation
+		}
+		if trigger, ok := triggers[i]; ok {
+			key.Downstroke, key.Upstroke = trigger[0], trigger[1]
+		}
+
+		config.Keys = append(config.Keys, key)
+	}
 
-	return &ddConfig
+	return config, nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+)
+
+// Profile -> vendor file -> profile has to send the keyboard the same key tables
+func TestAntlerRoundTripProfile(t *testing.T) {
+	profile, err := keyboard.ParseProfile([]byte(`{
+		"model": "G75",
+		"turbo": true,
+		"defaultActuation": 1.8,
+		"rapidTrigger": {"enabled": true, "defaultDownstroke": 0.2, "defaultUpstroke": 0.0},
+		"light": {"enabled": true, "sequence": 10, "speed": 4, "brightness": 7, "direction": 1},
+		"actuationPoints": {"@wasd": 0.5, "SPACE": 1.0},
+		"rapidTriggers": {"@arrows": [0.5, 0.5], "W": [0.0, 0.1]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := newDDConfig("test", profile.Model, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := parseOneDDConfig(t, data).convertToCLIConfig()
+
+	wantAct, wantDS, wantUS, err := profile.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotAct, gotDS, gotUS, err := imported.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(gotAct, wantAct) || !bytes.Equal(gotDS, wantDS) || !bytes.Equal(gotUS, wantUS) {
+		t.Errorf("key tables differ after the round trip")
+	}
+	if imported.Model != profile.Model {
+		t.Errorf("imported as a %q profile, expected %q", imported.Model, profile.Model)
+	}
+}
+
+// A profile without a model is exported for the model asked for, and comes back as one for that model
+func TestAntlerRoundTripWithoutModel(t *testing.T) {
+	profile, err := keyboard.ParseProfile([]byte(`{"defaultActuation": 1.5, "actuationPoints": {"W": 0.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newDDConfig("test", profile.Model, profile); err == nil {
+		t.Fatal("expected an error without a model")
+	}
+
+	exported, err := newDDConfig("test", driver.KEYBOARD_G65, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := parseOneDDConfig(t, data).convertToCLIConfig()
+	if imported.Model != driver.KEYBOARD_G65 {
+		t.Errorf("imported as a %q profile, expected G65", imported.Model)
+	}
+	if imported.ActuationPoints["W"] != 0.5 || imported.DefaultActuation != 1.5 {
+		t.Errorf("keys differ after the round trip: %+v", imported)
+	}
+}
+
+func parseOneDDConfig(t *testing.T, data []byte) *DDConfig {
+	t.Helper()
+
+	configs, err := parseDrunkDeerConfigs(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("expected one profile, got %d", len(configs))
+	}
+	return configs[0]
+}
+
+func TestAntlerModelFromStorageName(t *testing.T) {
+	tests := map[string]string{
+		"DDKB_A75_Valorant":  "A75",
+		"ddkb-g65-typing":    "G65",
+		"DDKB_A75Pro_x":      "A75",
+		"DDKB_G60something":  "G60",
+		"DDKB_X_G60ish":      "",
+		"A75":                "A75",
+		"abc":                "",
+		"":                   "",
+		"DDKB_G75_G60ish_A1": "G75",
+	}
+
+	for name, want := range tests {
+		config := &DDConfig{StorageName: name}
+		model, err := config.modelFromStorageName()
+		if model != want || (err == nil) != (want != "") {
+			t.Errorf("%q: got %q (%v), expected %q", name, model, err, want)
+		}
+	}
+}
+
+// Not the web driver's backup format, which hasn't been checked, only the shapes import accepts
+func TestParseDrunkDeerConfigsMultiple(t *testing.T) {
+	key := `{"keyname": "W", "action_point": 2, "downstroke": 0, "upstroke": 0}`
+	data := []byte(`[
+		{"storagename": "DDKB_A75_Valorant", "showname": "Valorant", "keys_array": [` + key + `]},
+		{"wrapped": {"storagename": "DDKB_G65_Typing", "showname": "Typing", "keys_array": [` + key + `]}},
+		{"storagename": "DDKB_A75_Valorant", "showname": "Valorant", "keys_array": [` + key + `]},
+		{"storagename": "DDKB_A75_x", "showname": "  ", "keys_array": [` + key + `]}
+	]`)
+
+	configs, err := parseDrunkDeerConfigs(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := antlerProfileNames(configs)
+	want := []string{"Valorant.json", "Typing.json", "Valorant-2.json", "antler-4.json"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("profile %d: expected %s, got %s", i, want[i], names[i])
+		}
+	}
+
+	if _, err := parseDrunkDeerConfigs([]byte(`{"profiles": []}`)); err == nil {
+		t.Error("expected an error for a file without profiles")
+	}
+	if _, err := parseDrunkDeerConfigs([]byte(`{"showname": "empty", "keys_array": []}`)); err == nil {
+		t.Error("expected an error for a profile without keys")
+	}
+}
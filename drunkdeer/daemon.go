@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+	"github.com/sstallion/go-hid"
+)
+
+const daemonPollInterval = 2 * time.Second
+
+// The daemon keeps every keyboard open so commands skip enumeration and the identity handshake
+type daemon struct {
+	app *App
+	ctx context.Context
+
+	mu        sync.Mutex                    // Serializes device access, the keyboards can't do two things at once
+	keyboards map[string]*keyboard.Keyboard // By HID path, serials aren't guaranteed to be unique or present
+	polled    []hid.DeviceInfo              // From the last poll, in `drunkdeer list` order
+
+	subscribersMu sync.Mutex
+	subscribers   map[chan deviceEvent]struct{}
+}
+
+func (a *App) runDaemon() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	path := daemonSocketPath(a.profilePath)
+	if client := dialDaemon(a.profilePath); client != nil {
+		client.Close()
+		handleError("Error starting daemon", fmt.Errorf("already running on %s", path))
+	}
+
+	// Left behind by a daemon that didn't shut down cleanly
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	handleError("Error starting daemon", err)
+	defer os.Remove(path)
+
+	d := &daemon{
+		app:         a,
+		ctx:         ctx,
+		keyboards:   make(map[string]*keyboard.Keyboard),
+		subscribers: make(map[chan deviceEvent]struct{}),
+	}
+
+	if a.args.DBus {
+		closeDBus, err := d.exportDBus()
+		handleError("Error starting D-Bus service", err)
+		defer closeDBus()
+		color.HiGreen("D-Bus service %s on the session bus", dbusName)
+	}
+
+	events, unsubscribe := d.subscribe()
+	defer unsubscribe()
+	go a.queueHooks(events)
+
+	// Before listening, so the first commands don't see an empty device list
+	d.syncDevices()
+	go d.watchDevices()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	color.HiGreen("Daemon listening on %s", path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Warn("accept failed", slog.Any("err", err))
+			continue
+		}
+
+		go d.serve(conn)
+	}
+
+	d.mu.Lock()
+	for _, kb := range d.keyboards {
+		kb.Close()
+	}
+	d.mu.Unlock()
+	color.White("Daemon stopped")
+}
+
+func (d *daemon) watchDevices() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(daemonPollInterval):
+		}
+
+		d.syncDevices()
+	}
+}
+
+// Opens newly plugged keyboards and drops the ones that went away
+func (d *daemon) syncDevices() {
+	devices := keyboard.FindDrunkDeerDevices()
+
+	d.mu.Lock()
+	d.polled = devices
+	present := make(map[string]bool)
+	added := make([]hid.DeviceInfo, 0)
+	for _, info := range devices {
+		present[info.Path] = true
+
+		if _, ok := d.keyboards[info.Path]; !ok {
+			added = append(added, info)
+		}
+	}
+
+	for path, kb := range d.keyboards {
+		gone := !present[path]
+		select {
+		case <-kb.Controller().Disconnected():
+			gone = true
+		default:
+		}
+
+		if gone {
+			kb.Close()
+			delete(d.keyboards, path)
+			d.publish(deviceEvent{Type: eventDisconnected, Serial: deviceSerial(kb)})
+		}
+	}
+	d.mu.Unlock()
+
+	// Opening waits for the identity handshake, commands for the other keyboards shouldn't wait on that
+	for i := range added {
+		kb, err := keyboard.Open(&added[i], keyboard.WithLogger(logger))
+		if err != nil {
+			logger.Warn("failed to open device", slog.String("path", added[i].Path), slog.Any("err", err))
+			continue
+		}
+
+		d.mu.Lock()
+		if d.ctx.Err() != nil {
+			// Shutting down, the keyboards were already closed
+			kb.Close()
+		} else {
+			d.add(kb)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Same index as `drunkdeer list` as of the last poll. Must be called with d.mu held.
+func (d *daemon) keyboardAt(index int) (*keyboard.Keyboard, string, error) {
+	devices := d.polled
+	if len(devices) == 0 {
+		return nil, "", keyboard.ErrNoDevices
+	}
+
+	if index < 0 || index >= len(devices) {
+		return nil, "", fmt.Errorf("invalid keyboard index: %d", index)
+	}
+
+	kb, ok := d.keyboards[devices[index].Path]
+	if !ok {
+		var err error
+		if kb, err = d.open(&devices[index]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return kb, deviceSerial(kb), nil
+}
+
+// Must be called with d.mu held
+func (d *daemon) open(info *hid.DeviceInfo) (*keyboard.Keyboard, error) {
+	kb, err := keyboard.Open(info, keyboard.WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	return d.add(kb), nil
+}
+
+// Returns the keyboard kept for the path, which is an existing one if a command opened it meanwhile. Must be
+// called with d.mu held.
+func (d *daemon) add(kb *keyboard.Keyboard) *keyboard.Keyboard {
+	path := kb.Info().Path
+	if existing, ok := d.keyboards[path]; ok {
+		kb.Close()
+		return existing
+	}
+
+	d.keyboards[path] = kb
+	d.publish(deviceEvent{Type: eventConnected, Serial: deviceSerial(kb), Model: kb.Identity().KeyboardModel})
+	if event := unknownModelEvent(deviceSerial(kb), kb.Identity()); event != nil {
+		d.publish(*event)
+	}
+	return kb
+}
+
+// Same fallback as setupDevice so the daemon and the CLI share journals
+func deviceSerial(kb *keyboard.Keyboard) string {
+	if serial := kb.Serial(); serial != "" {
+		return serial
+	}
+	return unknownSerial
+}
+
+func (d *daemon) publish(event deviceEvent) {
+	event.Time = time.Now()
+	logger.Info("daemon event", slog.String("type", event.Type), slog.String("device", event.Serial))
+
+	d.subscribersMu.Lock()
+	defer d.subscribersMu.Unlock()
+
+	for ch := range d.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	encoder := json.NewEncoder(conn)
+	send := func(resp rpcResponse) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		resp.JSONRPC = "2.0"
+		return encoder.Encode(resp)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			send(rpcResponse{ID: rpcNullID, Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+
+		if !validRPCID(req.ID) {
+			send(rpcResponse{ID: rpcNullID, Error: &rpcError{Code: rpcInvalidRequest, Message: "id must be a string, a number or null"}})
+			continue
+		}
+
+		if req.Method == "subscribe" {
+			if send(rpcResponse{ID: req.ID, Result: json.RawMessage("true")}) != nil {
+				return
+			}
+
+			// Nothing else is expected from a subscriber, reading is only there to notice it hanging up
+			hungUp := make(chan struct{})
+			go func() {
+				defer close(hungUp)
+				for scanner.Scan() {
+				}
+			}()
+
+			d.streamEvents(send, hungUp)
+			return
+		}
+
+		result, err := d.dispatch(req.Method, req.Params)
+		if len(req.ID) == 0 {
+			continue // Notification, no response wanted
+		}
+
+		if err != nil {
+			rpcErr := &rpcError{Code: rpcInternalError, Message: err.Error()}
+			errors.As(err, &rpcErr)
+			send(rpcResponse{ID: req.ID, Error: rpcErr})
+			continue
+		}
+
+		raw, err := json.Marshal(result)
+		if err != nil {
+			send(rpcResponse{ID: req.ID, Error: &rpcError{Code: rpcInternalError, Message: err.Error()}})
+			continue
+		}
+		send(rpcResponse{ID: req.ID, Result: raw})
+	}
+}
+
+func (d *daemon) subscribe() (<-chan deviceEvent, func()) {
+	events := make(chan deviceEvent, 32)
+
+	d.subscribersMu.Lock()
+	d.subscribers[events] = struct{}{}
+	d.subscribersMu.Unlock()
+
+	return events, func() {
+		d.subscribersMu.Lock()
+		delete(d.subscribers, events)
+		d.subscribersMu.Unlock()
+	}
+}
+
+// Until the daemon stops or the client goes away, whichever comes first
+func (d *daemon) streamEvents(send func(rpcResponse) error, hungUp <-chan struct{}) {
+	events, unsubscribe := d.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case event := <-events:
+			if err := send(rpcResponse{Method: "event", Params: event}); err != nil {
+				logger.Debug("subscriber went away", slog.Any("err", err))
+				return
+			}
+		case <-hungUp:
+			return
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) dispatch(method string, raw json.RawMessage) (any, error) {
+	decode := func(params any) error {
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil
+		}
+		if err := json.Unmarshal(raw, params); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch method {
+	case "devices":
+		return d.devices()
+	case "status":
+		var params deviceParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return d.status(params.Index)
+	case "load":
+		var params loadParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return d.load(params)
+	case "reset":
+		var params deviceParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return d.reset(params)
+	case "set":
+		var params setParams
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		return d.set(params)
+	}
+
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", method)}
+}
+
+func (d *daemon) devices() ([]deviceStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	devices := make([]deviceStatus, 0)
+	for i := range d.polled {
+		status, err := d.statusLocked(i)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *status)
+	}
+
+	return devices, nil
+}
+
+func (d *daemon) status(index int) (*deviceStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.statusLocked(index)
+}
+
+func (d *daemon) statusLocked(index int) (*deviceStatus, error) {
+	kb, serial, err := d.keyboardAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := kb.Identity()
+	status := &deviceStatus{
+		Index:    index,
+		Serial:   serial,
+		Model:    identity.KeyboardModel,
+		Firmware: identity.FirmwareVersion,
+	}
+
+	entries, err := readJournal(journalPath(d.app.profilePath, serial))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		status.Applied = &entries[len(entries)-1]
+	}
+
+	return status, nil
+}
+
+func (d *daemon) load(params loadParams) (*JournalEntry, error) {
+	profile, err := d.app.loadConfig(params.Profile)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("failed to load profile: %v", err)}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kb, serial, err := d.keyboardAt(params.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := kb.Apply(d.ctx, profile)
+	return d.recordLocked(kb, serial, params.Profile, state, err)
+}
+
+func (d *daemon) reset(params deviceParams) (*JournalEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kb, serial, err := d.keyboardAt(params.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := kb.Reset(d.ctx)
+	return d.recordLocked(kb, serial, "(reset)", state, err)
+}
+
+// Changes individual settings on top of whatever is loaded
+func (d *daemon) set(params setParams) (*JournalEntry, error) {
+	if err := validateSetParams(&params); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	kb, serial, err := d.keyboardAt(params.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "(defaults)"
+	state := &keyboard.State{
+		Light:       driver.DDLight{Sequence: driver.SEQUENCE_OFF, Speed: 5, Brightness: 9},
+		Actuations:  slices.Clone(kb.Controller().GetActuations()),
+		Downstrokes: slices.Clone(kb.Controller().GetDownstrokes()),
+		Upstrokes:   slices.Clone(kb.Controller().GetUpstrokes()),
+	}
+
+	entries, err := readJournal(journalPath(d.app.profilePath, serial))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		name = last.Profile
+		state = last.State.toKeyboardState()
+	}
+
+	if err := applySetParams(state, &params); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	err = kb.ApplyState(d.ctx, state)
+	return d.recordLocked(kb, serial, name+"+set", state, err)
+}
+
+// Same limits as a profile file, nothing past this point checks the ranges
+func validateSetParams(params *setParams) error {
+	profile := keyboard.Profile{
+		DefaultActuation: float32(driver.DEFAULT_ACTUATION) / 10,
+		ActuationPoints:  params.ActuationPoints,
+		RapidTriggers:    params.RapidTriggers,
+	}
+	if params.Light != nil {
+		profile.Light = *params.Light
+	}
+
+	errs := keyboard.Errors(profile.Validate(""))
+	if len(errs) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(errs))
+	for _, issue := range errs {
+		lines = append(lines, issue.String())
+	}
+	return fmt.Errorf("invalid settings: %s", strings.Join(lines, "; "))
+}
+
+func applySetParams(state *keyboard.State, params *setParams) error {
+	if params.Turbo != nil {
+		state.Turbo = *params.Turbo
+	}
+
+	if params.RapidTrigger != nil {
+		state.RapidTrigger = *params.RapidTrigger
+	}
+
+	if params.Light != nil {
+		profile := keyboard.Profile{Light: *params.Light}
+		state.Light = *profile.Lights()
+	}
+
+	points, err := keyboard.ExpandSelectors(params.ActuationPoints, nil)
+	if err != nil {
+		return fmt.Errorf("actuationPoints: %w", err)
+	}
+	for i, value := range points {
+		state.Actuations[i] = driver.ActuationFloatToByte(value)
+	}
+
+	triggers, err := keyboard.ExpandSelectors(params.RapidTriggers, nil)
+	if err != nil {
+		return fmt.Errorf("rapidTriggers: %w", err)
+	}
+	for i, value := range triggers {
+		state.Downstrokes[i] = driver.ActuationFloatToByte(value[0])
+		state.Upstrokes[i] = driver.ActuationFloatToByte(value[1])
+	}
+
+	return nil
+}
+
+func (d *daemon) recordLocked(kb *keyboard.Keyboard, serial, profile string, state *keyboard.State, applyErr error) (*JournalEntry, error) {
+	model := kb.Identity().KeyboardModel
+	if applyErr != nil {
+		d.publish(failedEvent(serial, model, profile, applyErr))
+		return nil, applyErr
+	}
+
+	entry, err := journalApplied(d.app.profilePath, serial, kb.Identity(), profile, newAppliedState(state))
+	if err != nil {
+		return nil, fmt.Errorf("applied, but failed to record it: %w", err)
+	}
+
+	d.publish(appliedEvent(serial, model, profile, entry))
+	return entry, nil
+}
+
+// Commands that read reports or take over the lighting, they'd fight the daemon for the device
+var exclusiveCommands = []string{"raw", "selftest", "calibrate", "diagnose", "overlay"}
+
+// Hands the command to a running daemon, returns false if there's none and we should talk to the device ourselves
+func (a *App) tryDaemon() bool {
+	if a.args.NoDaemon {
+		return false
+	}
+
+	exclusive := false
+	switch {
+	case a.args.Reset, a.args.Load != "", a.args.Command == "status", a.args.Command == "events":
+	case slices.Contains(exclusiveCommands, a.args.Command):
+		exclusive = true
+	default:
+		return false
+	}
+
+	client := dialDaemon(a.profilePath)
+	if client != nil && exclusive {
+		client.Close()
+		handleError("Error", fmt.Errorf("%s needs the keyboard to itself but the daemon has it open, stop the daemon first", a.args.Command))
+	}
+	if client == nil {
+		if a.args.Command == "events" {
+			handleError("Error", fmt.Errorf("no daemon running, start one with: drunkdeer daemon"))
+		}
+		return false
+	}
+	defer client.Close()
+	logger.Debug("forwarding to daemon", slog.String("socket", daemonSocketPath(a.profilePath)))
+
+	switch {
+	case a.args.Reset:
+		var entry JournalEntry
+		err := client.call("reset", deviceParams{Index: a.keyboardIndex}, &entry)
+		handleError("Error resetting device", err)
+		color.White("Reset complete")
+	case a.args.Load != "":
+		var entry JournalEntry
+		err := client.call("load", loadParams{Index: a.keyboardIndex, Profile: a.args.Load}, &entry)
+		handleError("Error loading profile", err)
+
+		color.White("Loaded %s%s%s",
+			color.GreenString(a.args.Load),
+			color.WhiteString(" for "),
+			color.HiBlueString("DrunkDeer %s", entry.Model))
+	case a.args.Command == "status":
+		var status deviceStatus
+		err := client.call("status", deviceParams{Index: a.keyboardIndex}, &status)
+		handleError("Error getting status", err)
+
+		if status.Applied == nil {
+			color.HiRed("Nothing has been applied to this device yet")
+			return true
+		}
+
+		identity := &driver.DDKeyboardIdentity{KeyboardModel: status.Model, FirmwareVersion: status.Firmware}
+		printStatus(status.Serial, identity, status.Applied)
+	case a.args.Command == "events":
+		err := client.subscribe(printDaemonEvent)
+		handleError("Lost connection to daemon", err)
+	}
+
+	return true
+}
+
+func printDaemonEvent(event deviceEvent) {
+	timestamp := event.Time.Local().Format(time.TimeOnly)
+
+	switch event.Type {
+	case eventConnected:
+		color.HiGreen("%s %s connected (DrunkDeer %s)", timestamp, event.Serial, event.Model)
+	case eventDisconnected:
+		color.HiYellow("%s %s disconnected", timestamp, event.Serial)
+	case eventApplied:
+		color.White("%s %s applied %s (%s)", timestamp, event.Serial,
+			color.GreenString(event.Profile), shortHash(event.StateHash))
+	case eventFailed:
+		color.HiRed("%s %s failed to apply %s: %s", timestamp, event.Serial, event.Profile, event.Error)
+	case eventUnknownModel:
+		color.HiYellow("%s %s reports unknown model %s, treating it as %s", timestamp, event.Serial, event.ModelID, event.Model)
+	default:
+		color.White("%s %s %s", timestamp, event.Serial, event.Type)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/sstallion/go-hid"
+)
+
+func newTestDaemon(t *testing.T) (*daemon, net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	d := &daemon{ctx: ctx, subscribers: make(map[chan deviceEvent]struct{})}
+	server, client := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.serve(server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	return d, client
+}
+
+func TestDaemonSubscriberHangUp(t *testing.T) {
+	d, client := newTestDaemon(t)
+
+	client.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"subscribe"}` + "\n"))
+	if _, err := bufio.NewReader(client).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.subscribersMu.Lock()
+		left := len(d.subscribers)
+		d.subscribersMu.Unlock()
+
+		if left == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber is still registered after the client hung up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDaemonEchoesIDs(t *testing.T) {
+	_, client := newTestDaemon(t)
+	reader := bufio.NewReader(client)
+
+	tests := map[string]string{
+		`{"jsonrpc":"2.0","id":"abc","method":"nope"}`: `"abc"`,
+		`{"jsonrpc":"2.0","id":7,"method":"nope"}`:     `7`,
+		`{"jsonrpc":"2.0","id":null,"method":"nope"}`:  `null`,
+		`{"jsonrpc":"2.0","id":{},"method":"nope"}`:    `null`,
+		`not json`: `null`,
+	}
+
+	for request, want := range tests {
+		client.Write([]byte(request + "\n"))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp struct {
+			ID    json.RawMessage `json:"id"`
+			Error *rpcError       `json:"error"`
+		}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.ID) != want || resp.Error == nil {
+			t.Errorf("%s: got %s, expected an error with id %s", request, line, want)
+		}
+	}
+}
+
+func TestDaemonSetRejectsOutOfRange(t *testing.T) {
+	d, _ := newTestDaemon(t)
+
+	tests := map[string]setParams{
+		"actuation":  {ActuationPoints: map[string]float32{"W": 30}},
+		"trigger":    {RapidTriggers: map[string][2]float32{"A": {0.2, -1}}},
+		"brightness": {Light: &keyboard.LightSettings{Brightness: 300}},
+		"sequence":   {Light: &keyboard.LightSettings{Sequence: 99}},
+		"direction":  {Light: &keyboard.LightSettings{Direction: 256}},
+		"key":        {ActuationPoints: map[string]float32{"NOPE": 1}},
+	}
+
+	for name, params := range tests {
+		_, err := d.set(params)
+
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != rpcInvalidParams {
+			t.Errorf("%s: expected invalid params, got %v", name, err)
+		}
+	}
+}
+
+func TestDaemonKeyboardAtUsesPolledDevices(t *testing.T) {
+	d, _ := newTestDaemon(t)
+
+	if _, _, err := d.keyboardAt(0); !errors.Is(err, keyboard.ErrNoDevices) {
+		t.Fatalf("expected no devices before the first poll, got %v", err)
+	}
+
+	d.polled = []hid.DeviceInfo{{Path: "/dev/hidraw9"}}
+	if _, _, err := d.keyboardAt(1); err == nil {
+		t.Fatal("expected an error for an index past the polled devices")
+	}
+}
+
+func TestDBusSetLightRejectsOutOfRange(t *testing.T) {
+	d, _ := newTestDaemon(t)
+	service := &dbusService{d: d}
+
+	_, err := service.SetLight(0, true, 99, 5, 9, 0)
+	if err == nil || err.Name != dbusInvalidArgs {
+		t.Fatalf("expected %s, got %v", dbusInvalidArgs, err)
+	}
+}
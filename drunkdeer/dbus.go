@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	dbusName      = "org.drunkdeer.Keyboard"
+	dbusInterface = "org.drunkdeer.Keyboard"
+	dbusPath      = dbus.ObjectPath("/org/drunkdeer/Keyboard")
+
+	dbusInvalidArgs = "org.freedesktop.DBus.Error.InvalidArgs"
+)
+
+const dbusIntrospection = `
+<node>
+	<interface name="` + dbusInterface + `">
+		<method name="LoadProfile">
+			<arg name="index" direction="in" type="i"/>
+			<arg name="profile" direction="in" type="s"/>
+			<arg name="stateHash" direction="out" type="s"/>
+		</method>
+		<method name="ListProfiles">
+			<arg name="profiles" direction="out" type="as"/>
+		</method>
+		<method name="GetStatus">
+			<arg name="index" direction="in" type="i"/>
+			<arg name="status" direction="out" type="a{sv}"/>
+		</method>
+		<method name="SetLight">
+			<arg name="index" direction="in" type="i"/>
+			<arg name="enabled" direction="in" type="b"/>
+			<arg name="sequence" direction="in" type="i"/>
+			<arg name="speed" direction="in" type="i"/>
+			<arg name="brightness" direction="in" type="i"/>
+			<arg name="direction" direction="in" type="i"/>
+			<arg name="stateHash" direction="out" type="s"/>
+		</method>
+		<signal name="DeviceConnected">
+			<arg name="serial" type="s"/>
+			<arg name="model" type="s"/>
+		</signal>
+		<signal name="DeviceDisconnected">
+			<arg name="serial" type="s"/>
+		</signal>
+		<signal name="ProfileApplied">
+			<arg name="serial" type="s"/>
+			<arg name="profile" type="s"/>
+			<arg name="stateHash" type="s"/>
+		</signal>
+		<signal name="ProfileFailed">
+			<arg name="serial" type="s"/>
+			<arg name="profile" type="s"/>
+			<arg name="error" type="s"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// D-Bus front for the daemon, same operations as the JSON-RPC socket
+type dbusService struct {
+	d *daemon
+}
+
+// Returns a function that releases the name and closes the connection
+func (d *daemon) exportDBus() (func(), error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	service := &dbusService{d: d}
+	if err := conn.Export(service, dbusPath, dbusInterface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := conn.Export(introspect.Introspectable(dbusIntrospection), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("%s is already taken on the session bus", dbusName)
+	}
+
+	events, unsubscribe := d.subscribe()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				emitDBusSignal(conn, event)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		unsubscribe()
+		conn.ReleaseName(dbusName)
+		conn.Close()
+	}, nil
+}
+
+func emitDBusSignal(conn *dbus.Conn, event deviceEvent) {
+	var err error
+	switch event.Type {
+	case eventConnected:
+		err = conn.Emit(dbusPath, dbusInterface+".DeviceConnected", event.Serial, event.Model)
+	case eventDisconnected:
+		err = conn.Emit(dbusPath, dbusInterface+".DeviceDisconnected", event.Serial)
+	case eventApplied:
+		err = conn.Emit(dbusPath, dbusInterface+".ProfileApplied", event.Serial, event.Profile, event.StateHash)
+	case eventFailed:
+		err = conn.Emit(dbusPath, dbusInterface+".ProfileFailed", event.Serial, event.Profile, event.Error)
+	}
+
+	if err != nil {
+		logger.Warn("failed to emit D-Bus signal", slog.String("type", event.Type), slog.Any("err", err))
+	}
+}
+
+func (s *dbusService) LoadProfile(index int32, profile string) (string, *dbus.Error) {
+	entry, err := s.d.load(loadParams{Index: int(index), Profile: profile})
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+
+	return entry.StateHash, nil
+}
+
+func (s *dbusService) ListProfiles() ([]string, *dbus.Error) {
+	profiles, err := listProfiles(s.d.app.profilePath)
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	return profiles, nil
+}
+
+func (s *dbusService) GetStatus(index int32) (map[string]dbus.Variant, *dbus.Error) {
+	status, err := s.d.status(int(index))
+	if err != nil {
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	result := map[string]dbus.Variant{
+		"serial":   dbus.MakeVariant(status.Serial),
+		"model":    dbus.MakeVariant(status.Model),
+		"firmware": dbus.MakeVariant(status.Firmware),
+	}
+
+	// Left out entirely when nothing was applied yet, a{sv} has no null
+	if status.Applied != nil {
+		result["profile"] = dbus.MakeVariant(status.Applied.Profile)
+		result["stateHash"] = dbus.MakeVariant(status.Applied.StateHash)
+		result["applied"] = dbus.MakeVariant(status.Applied.Timestamp.Unix())
+		result["turbo"] = dbus.MakeVariant(status.Applied.State.Turbo)
+		result["rapidTrigger"] = dbus.MakeVariant(status.Applied.State.RapidTrigger)
+	}
+
+	return result, nil
+}
+
+func (s *dbusService) SetLight(index int32, enabled bool, sequence, speed, brightness, direction int32) (string, *dbus.Error) {
+	params := setParams{
+		Index: int(index),
+		Light: &keyboard.LightSettings{
+			Enabled:    enabled,
+			Sequence:   int(sequence),
+			Speed:      int(speed),
+			Brightness: int(brightness),
+			Direction:  int(direction),
+		},
+	}
+	if err := validateSetParams(&params); err != nil {
+		return "", dbus.NewError(dbusInvalidArgs, []any{err.Error()})
+	}
+
+	entry, err := s.d.set(params)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+
+	return entry.StateHash, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+const diagnoseIdleTime = 5 * time.Second
+
+func (a *App) handleDiagnose() {
+	actuations, downstrokes, upstrokes, rt := a.appliedTables()
+	detector := keyboard.NewChatterDetector(actuations, downstrokes, upstrokes, rt)
+
+	// Ctrl+C ends the typing phase, the report still gets printed
+	trackCtx, stopTracking := context.WithCancel(context.Background())
+	defer stopTracking()
+
+	travel, err := a.kb.TrackKeys(trackCtx)
+	handleError("Error starting key tracking", err)
+
+	color.HiBlue("Keep your hands off the keyboard for %s...", diagnoseIdleTime)
+	idle := time.After(diagnoseIdleTime)
+idleLoop:
+	for {
+		select {
+		case t, ok := <-travel:
+			if !ok {
+				handleError("Key tracking stopped", fmt.Errorf("device disconnected"))
+			}
+			detector.Idle(t)
+		case <-idle:
+			break idleLoop
+		case <-a.ctx.Done():
+			return
+		}
+	}
+
+	color.HiBlue("Now type normally, focus on the keys that double-fire")
+	color.White("Stops after %s or on Ctrl+C", a.args.Duration)
+
+	timeout := time.After(a.args.Duration)
+typingLoop:
+	for {
+		select {
+		case t, ok := <-travel:
+			if !ok {
+				break typingLoop
+			}
+			detector.Typing(t)
+		case <-timeout:
+			break typingLoop
+		case <-a.ctx.Done():
+			break typingLoop
+		}
+	}
+	fmt.Println()
+
+	a.showDiagnosis(detector.Result())
+}
+
+func (a *App) showDiagnosis(result []keyboard.KeyDiagnosis) {
+	flagged := 0
+	for _, key := range result {
+		if !key.Flagged() {
+			continue
+		}
+
+		if flagged == 0 {
+			fmt.Printf("%-10s %6s %8s %7s %10s %9s %8s %8s\n",
+				"KEY", "IDLE", "CHATTER", "NOISE", "ACTUATION", "MIN ACT", "RT", "MIN RT")
+		}
+		flagged++
+
+		fmt.Printf("%-10s %6d %8d %5.1fmm %8.1fmm %7.1fmm %6.1fmm %6.1fmm\n",
+			key.Key, key.IdleCrossings, key.Chatter, key.Noise,
+			key.Actuation, key.MinActuation, key.RapidTrigger, key.MinRapidTrigger)
+	}
+
+	if flagged == 0 {
+		color.HiGreen("No spurious actuations, the current settings look safe")
+		return
+	}
+
+	color.HiYellow("%d keys may fire on their own, raise their actuation/rapid trigger to at least the suggested minimum", flagged)
+}
+
+// What the keyboard is running, as far as the journal knows
+func (a *App) appliedTables() ([]byte, []byte, []byte, bool) {
+	entries, err := readJournal(a.journalPath())
+	handleError("Error reading journal", err)
+
+	if len(entries) == 0 {
+		color.HiYellow("Nothing applied to this device yet, assuming firmware defaults")
+		actuations := make([]byte, len(driver.KEYBOARD_LAYOUT))
+		for i := range actuations {
+			actuations[i] = driver.DEFAULT_ACTUATION
+		}
+		return actuations, nil, nil, false
+	}
+
+	state := entries[len(entries)-1].State.toKeyboardState()
+	return state.Actuations, state.Downstrokes, state.Upstrokes, state.RapidTrigger
+}
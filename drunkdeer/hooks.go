@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+const (
+	hookTimeout = 10 * time.Second
+	// How long to wait for the output after the hook exits or is killed, background children can keep it open
+	hookWaitDelay = 2 * time.Second
+	// Daemon events waiting for their hooks, more than that and hooks are hopelessly behind
+	hookQueueSize = 256
+
+	eventConnected    = "connected"
+	eventDisconnected = "disconnected"
+	eventApplied      = "applied"
+	eventFailed       = "failed"
+	eventUnknownModel = "unknown-model"
+)
+
+var hookEvents = []string{eventConnected, eventDisconnected, eventApplied, eventFailed, eventUnknownModel}
+
+type Hook struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// Settings for the CLI itself, not the keyboard. Lives outside the profile directory so it never shows up as a profile.
+type CLIConfig struct {
+	Hooks map[string][]Hook `json:"hooks"`
+}
+
+func cliConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "drunkdeer", "config.json"), nil
+}
+
+// A missing config is fine, a broken one is reported but doesn't stop the command
+func (a *App) loadCLIConfig() {
+	a.config = &CLIConfig{}
+
+	path, err := cliConfigPath()
+	if err != nil {
+		logger.Debug("no config directory", slog.Any("err", err))
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		color.HiYellow("Warning: failed to read %s: %v", path, err)
+		return
+	}
+
+	if err := json.Unmarshal(data, a.config); err != nil {
+		color.HiYellow("Warning: failed to parse %s: %v", path, err)
+		a.config = &CLIConfig{}
+		return
+	}
+
+	for event := range a.config.Hooks {
+		if !isHookEvent(event) {
+			color.HiYellow("Warning: unknown hook event %q in %s (known: %s)", event, path, strings.Join(hookEvents, ", "))
+		}
+	}
+}
+
+func isHookEvent(event string) bool {
+	for _, known := range hookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs every hook for the event, one after another. Hooks can't fail the command, only log.
+func (a *App) runHooks(event deviceEvent) {
+	if a.config == nil || len(a.config.Hooks[event.Type]) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn("failed to encode hook payload", slog.Any("err", err))
+		return
+	}
+
+	env := append(os.Environ(),
+		"DRUNKDEER_EVENT="+event.Type,
+		"DRUNKDEER_SERIAL="+event.Serial,
+		"DRUNKDEER_MODEL="+event.Model,
+		"DRUNKDEER_MODEL_ID="+event.ModelID,
+		"DRUNKDEER_PROFILE="+event.Profile,
+		"DRUNKDEER_STATE_HASH="+event.StateHash,
+		"DRUNKDEER_ERROR="+event.Error,
+		"DRUNKDEER_TIME="+event.Time.Format(time.RFC3339),
+	)
+
+	for _, hook := range a.config.Hooks[event.Type] {
+		runHook(hook, env, payload, event.Type)
+	}
+}
+
+// Runs hooks for the daemon's events in order, without holding up the daemon. If the hooks fall too far
+// behind, events are dropped with a warning rather than queued forever.
+func (a *App) queueHooks(events <-chan deviceEvent) {
+	queue := make(chan deviceEvent, hookQueueSize)
+	defer close(queue)
+
+	go func() {
+		for event := range queue {
+			a.runHooks(event)
+		}
+	}()
+
+	for event := range events {
+		select {
+		case queue <- event:
+		default:
+			logger.Warn("hooks are falling behind, skipping event", slog.String("event", event.Type), slog.String("device", event.Serial))
+		}
+	}
+}
+
+func runHook(hook Hook, env []string, payload []byte, event string) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.WaitDelay = hookWaitDelay
+
+	output, err := cmd.CombinedOutput()
+	attrs := []any{slog.String("event", event), slog.String("command", hook.Command)}
+	if len(output) > 0 {
+		attrs = append(attrs, slog.String("output", strings.TrimSpace(string(output))))
+	}
+
+	if err != nil {
+		logger.Warn("hook failed", append(attrs, slog.Any("err", err))...)
+		return
+	}
+	logger.Info("hook ran", attrs...)
+}
+
+func appliedEvent(serial, model, profile string, entry *JournalEntry) deviceEvent {
+	event := deviceEvent{Type: eventApplied, Serial: serial, Model: model, Profile: profile}
+	if entry != nil {
+		event.StateHash = entry.StateHash
+	}
+	return event
+}
+
+func failedEvent(serial, model, profile string, err error) deviceEvent {
+	return deviceEvent{Type: eventFailed, Serial: serial, Model: model, Profile: profile, Error: err.Error()}
+}
+
+// Nil when the model bytes are known
+func unknownModelEvent(serial string, identity *driver.DDKeyboardIdentity) *deviceEvent {
+	if identity == nil || !identity.UnknownModel {
+		return nil
+	}
+
+	return &deviceEvent{
+		Type:    eventUnknownModel,
+		Serial:  serial,
+		Model:   identity.KeyboardModel,
+		ModelID: hex.EncodeToString(identity.ModelID),
+	}
+}
+
+// Reports a failed apply to the hooks before exiting like handleError
+func (a *App) handleApplyError(message, profile string, err error) {
+	if err == nil {
+		return
+	}
+
+	model := ""
+	if identity := a.kb.Identity(); identity != nil {
+		model = identity.KeyboardModel
+	}
+	a.runHooks(failedEvent(a.serial, model, profile, err))
+	handleError(message, err)
+}
+
+func (a *App) checkUnknownModel(kb *keyboard.Keyboard, serial string) {
+	if event := unknownModelEvent(serial, kb.Identity()); event != nil {
+		logger.Warn("unknown keyboard model", slog.String("modelId", event.ModelID), slog.String("assumed", event.Model))
+		a.runHooks(*event)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+const (
+	journalDirName = "journal"
+	unknownSerial  = "unknown"
+)
+
+// The keyboard only echoes what we send, so this is the only place that knows what's loaded
+type AppliedState struct {
+	Turbo        bool       `json:"turbo"`
+	RapidTrigger bool       `json:"rapidTrigger"`
+	Light        JournalLED `json:"light"`
+	Actuations   []int      `json:"actuations"`
+	Downstrokes  []int      `json:"downstrokes"`
+	Upstrokes    []int      `json:"upstrokes"`
+}
+
+type JournalLED struct {
+	Direction  byte `json:"direction"`
+	Sequence   byte `json:"sequence"`
+	Speed      byte `json:"speed"`
+	Brightness byte `json:"brightness"`
+}
+
+type JournalEntry struct {
+	Serial    string       `json:"serial"`
+	Model     string       `json:"model"`
+	Firmware  string       `json:"firmware"`
+	Timestamp time.Time    `json:"timestamp"`
+	Profile   string       `json:"profile"`
+	StateHash string       `json:"stateHash"` // See AppliedState.Hash
+	State     AppliedState `json:"state"`
+}
+
+func newAppliedState(state *keyboard.State) AppliedState {
+	return AppliedState{
+		Turbo:        state.Turbo,
+		RapidTrigger: state.RapidTrigger,
+		Light: JournalLED{
+			Direction:  state.Light.Direction,
+			Sequence:   state.Light.Sequence,
+			Speed:      state.Light.Speed,
+			Brightness: state.Light.Brightness,
+		},
+		Actuations:  bytesToInts(state.Actuations),
+		Downstrokes: bytesToInts(state.Downstrokes),
+		Upstrokes:   bytesToInts(state.Upstrokes),
+	}
+}
+
+func (s *AppliedState) toKeyboardState() *keyboard.State {
+	return &keyboard.State{
+		Turbo:        s.Turbo,
+		RapidTrigger: s.RapidTrigger,
+		Light: driver.DDLight{
+			Direction:  s.Light.Direction,
+			Sequence:   s.Light.Sequence,
+			Speed:      s.Light.Speed,
+			Brightness: s.Light.Brightness,
+		},
+		Actuations:  intsToBytes(s.Actuations),
+		Downstrokes: intsToBytes(s.Downstrokes),
+		Upstrokes:   intsToBytes(s.Upstrokes),
+	}
+}
+
+// SHA-256 of the resolved state that was sent (tables, toggles and lighting), not of the profile file. Two
+// profiles that load the same thing hash the same, and the same file hashes differently once a profile it
+// extends changes.
+func (s *AppliedState) Hash() string {
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func journalPath(profileDir, serial string) string {
+	return filepath.Join(profileDir, journalDirName, keyboard.SerialFileName(serial)+".jsonl")
+}
+
+func (a *App) journalPath() string {
+	return journalPath(a.profilePath, a.serial)
+}
+
+func journalApplied(profileDir, serial string, identity *driver.DDKeyboardIdentity, profile string, state AppliedState) (*JournalEntry, error) {
+	entry := JournalEntry{
+		Serial:    serial,
+		Model:     identity.KeyboardModel,
+		Firmware:  identity.FirmwareVersion,
+		Timestamp: time.Now(),
+		Profile:   profile,
+		StateHash: state.Hash(),
+		State:     state,
+	}
+
+	path := journalPath(profileDir, serial)
+	if err := appendJournal(path, &entry); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("recorded applied state",
+		slog.String("device", serial),
+		slog.String("profile", profile),
+		slog.String("stateHash", shortHash(entry.StateHash)),
+		slog.String("journal", path))
+
+	return &entry, nil
+}
+
+// Nil if the entry couldn't be written
+func (a *App) recordApplied(profile string, state AppliedState) *JournalEntry {
+	// Failing to journal shouldn't fail the load, the keyboard already has the settings
+	entry, err := journalApplied(a.profilePath, a.serial, a.kb.Identity(), profile, state)
+	if err != nil {
+		color.HiYellow("Warning: failed to record applied state: %v", err)
+	}
+	return entry
+}
+
+func appendJournal(path string, entry *JournalEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return file.Sync()
+}
+
+func readJournal(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	entries := make([]JournalEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// A torn write from a killed process shouldn't hide the rest of the history
+			logger.Warn("skipping malformed journal line", slog.String("journal", path), slog.Any("err", err))
+			continue
+		}
+		// Entries from before the rename have it under "hash", it's the same hash of the state
+		if entry.StateHash == "" {
+			entry.StateHash = entry.State.Hash()
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// nil, after saying so, when nothing has been applied yet
+func (a *App) lastApplied() *JournalEntry {
+	entries, err := readJournal(a.journalPath())
+	handleError("Error reading journal", err)
+
+	if len(entries) == 0 {
+		color.HiRed("Nothing has been applied to this device yet")
+		return nil
+	}
+
+	return &entries[len(entries)-1]
+}
+
+func (a *App) handleStatus() {
+	if entry := a.lastApplied(); entry != nil {
+		printStatus(a.serial, a.kb.Identity(), entry)
+	}
+}
+
+func printStatus(serial string, identity *driver.DDKeyboardIdentity, entry *JournalEntry) {
+	color.HiGreen("DrunkDeer %s (serial: %s, firmware: v%s)", identity.KeyboardModel, serial, identity.FirmwareVersion)
+	fmt.Printf("Profile:       %s\n", color.GreenString(entry.Profile))
+	fmt.Printf("Applied:       %s\n", entry.Timestamp.Local().Format(time.DateTime))
+	fmt.Printf("State hash:    %s\n", shortHash(entry.StateHash))
+	fmt.Printf("Turbo:         %v\n", entry.State.Turbo)
+	fmt.Printf("Rapid trigger: %v\n", entry.State.RapidTrigger)
+	fmt.Printf("Light:         sequence %d, speed %d, brightness %d, direction %d\n",
+		entry.State.Light.Sequence, entry.State.Light.Speed,
+		entry.State.Light.Brightness, entry.State.Light.Direction)
+}
+
+func (a *App) handleDump() {
+	entry := a.lastApplied()
+	if entry == nil {
+		return
+	}
+
+	color.HiGreen("%s applied %s", entry.Profile, entry.Timestamp.Local().Format(time.DateTime))
+	fmt.Printf("%-10s %10s %10s %10s\n", "KEY", "ACTUATION", "DOWN", "UP")
+	for i, key := range driver.KEYBOARD_LAYOUT {
+		if key == "" || i >= len(entry.State.Actuations) {
+			continue
+		}
+
+		fmt.Printf("%-10s %8.1fmm %8.1fmm %8.1fmm\n", key,
+			tableValueToMM(entry.State.Actuations, i),
+			tableValueToMM(entry.State.Downstrokes, i),
+			tableValueToMM(entry.State.Upstrokes, i))
+	}
+}
+
+func (a *App) handleHistory() {
+	from, to, err := parseHistoryRange(a.args.CmdValue, time.Now())
+	handleError("Invalid history range", err)
+
+	entries, err := readJournal(a.journalPath())
+	handleError("Error reading journal", err)
+
+	shown := 0
+	for _, entry := range entries {
+		if entry.Timestamp.Before(from) || (!to.IsZero() && !entry.Timestamp.Before(to)) {
+			continue
+		}
+
+		fmt.Printf("%s  %s  %s\n",
+			color.WhiteString(entry.Timestamp.Local().Format(time.DateTime)),
+			color.GreenString("%-24s", entry.Profile),
+			color.RGB(0x80, 0x80, 0x80).Sprint(shortHash(entry.StateHash)))
+		shown++
+	}
+
+	if shown == 0 {
+		color.HiRed("No applied profiles in that range")
+	}
+}
+
+// Accepts nothing (everything), a duration such as 24h, "yesterday", or a day such as 2006-01-02.
+// A zero upper bound means "until now".
+func parseHistoryRange(value string, now time.Time) (time.Time, time.Time, error) {
+	switch value {
+	case "":
+		return time.Time{}, time.Time{}, nil
+	case "today":
+		value = now.Format(time.DateOnly)
+	case "yesterday":
+		value = now.AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), time.Time{}, nil
+	}
+
+	day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected a duration (24h), today, yesterday or a date (2006-01-02), got %q", value)
+	}
+
+	return day, day.AddDate(0, 0, 1), nil
+}
+
+func tableValueToMM(table []int, index int) float32 {
+	if index >= len(table) {
+		return 0
+	}
+	return float32(table[index]) / 10
+}
+
+func bytesToInts(b []byte) []int {
+	out := make([]int, len(b))
+	for i, v := range b {
+		out[i] = int(v)
+	}
+	return out
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func intsToBytes(v []int) []byte {
+	out := make([]byte, len(v))
+	for i, n := range v {
+		out[i] = byte(n)
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryRange(t *testing.T) {
+	now := time.Date(2024, 5, 10, 15, 30, 0, 0, time.Local)
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.Local) }
+
+	tests := []struct {
+		value    string
+		from, to time.Time
+		err      bool
+	}{
+		{value: ""},
+		{value: "24h", from: now.Add(-24 * time.Hour)},
+		{value: "90m", from: now.Add(-90 * time.Minute)},
+		{value: "today", from: day(10), to: day(11)},
+		{value: "yesterday", from: day(9), to: day(10)},
+		{value: "2024-05-01", from: day(1), to: day(2)},
+		{value: "last week", err: true},
+		{value: "2024-13-01", err: true},
+	}
+
+	for _, test := range tests {
+		from, to, err := parseHistoryRange(test.value, now)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if !from.Equal(test.from) || !to.Equal(test.to) {
+			t.Errorf("%q: got %v - %v, expected %v - %v", test.value, from, to, test.from, test.to)
+		}
+	}
+}
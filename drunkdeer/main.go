@@ -1,44 +1,68 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
 
-	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
 	"github.com/alexflint/go-arg"
 	"github.com/fatih/color"
 	"github.com/sstallion/go-hid"
 )
 
 const (
-	defaultKeyboardIndex      = 0
-	defaultProfilePath        = "~/.drunkdeer"
-	defaultWaitPerInstruction = 100 * time.Millisecond
+	defaultKeyboardIndex = 0
+	defaultProfilePath   = "~/.drunkdeer"
 )
 
 var (
-	debug = false
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
 )
 
 type App struct {
+	ctx           context.Context
 	keyboardIndex int
-	device        *hid.Device
-	controller    *driver.DrunkDeerController
+	kb            *keyboard.Keyboard
+	serial        string
 	profilePath   string
+	config        *CLIConfig
 	args          Args
+	logFile       *os.File
 }
 
-func debugPrintf(format string, v ...interface{}) {
-	if !debug {
-		return
+// Logs never go to stdout, that's reserved for command output. The log file, if any, is returned for closing.
+func setupLogger(args *Args) (*slog.Logger, *os.File, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(args.LogLevel))); err != nil {
+		return nil, nil, fmt.Errorf("invalid log level %q", args.LogLevel)
+	}
+
+	if args.Debug {
+		level = slog.LevelDebug
+	}
+
+	var out io.Writer = os.Stderr
+	var file *os.File
+	if args.LogFile != "" {
+		var err error
+		file, err = os.OpenFile(args.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = file
 	}
 
-	if format[len(format)-1] != '\n' {
-		format += "\n"
+	opts := &slog.HandlerOptions{Level: level}
+	if args.LogJSON {
+		return slog.New(slog.NewJSONHandler(out, opts)), file, nil
 	}
-	debugPrefix := color.HiGreenString("[DEBUG] ")
-	fmt.Printf(debugPrefix+format, v...)
+
+	return slog.New(slog.NewTextHandler(out, opts)), file, nil
 }
 
 func main() {
@@ -51,17 +75,24 @@ func main() {
 	}
 
 	app.parseArgs()
+	defer app.cleanup()
 	app.setupProfilePath()
+	app.loadCLIConfig()
 	app.handleArgs()
+	if app.tryDaemon() {
+		return
+	}
 	app.setupDevice()
-	defer app.cleanup()
 
 	app.run()
 }
 
 func (a *App) parseArgs() {
 	arg.MustParse(&a.args)
-	debug = a.args.Debug
+
+	l, file, err := setupLogger(&a.args)
+	handleError("Error setting up logging", err)
+	logger, a.logFile = l, file
 }
 
 func (a *App) setupProfilePath() {
@@ -79,31 +110,55 @@ func (a *App) setupProfilePath() {
 
 func (a *App) setupDevice() {
 	var err error
-	a.device, err = getDevice(a.keyboardIndex)
+	a.kb, err = keyboard.OpenIndex(a.keyboardIndex, keyboard.WithLogger(logger))
 	handleError("Error:", err)
-	debugPrintf("Device opened")
-
-	a.controller = driver.NewDrunkDeerController(a.device)
-	a.controller.GetIdentity()
-	debugPrintf("Created controller")
 
-	if debug {
-		a.controller.SetDebug(true)
+	a.serial = a.kb.Serial()
+	if a.serial == "" {
+		logger.Debug("could not read device serial", slog.String("fallback", unknownSerial))
+		a.serial = unknownSerial
 	}
+	logger.Debug("device opened", slog.Int("index", a.keyboardIndex), slog.String("device", a.serial))
+	a.checkUnknownModel(a.kb, a.serial)
 }
 
 func (a *App) cleanup() {
-	if a.device != nil {
-		a.device.Close()
+	if a.kb != nil {
+		a.kb.Close()
+	}
+
+	if a.logFile != nil {
+		a.logFile.Sync()
+		a.logFile.Close()
 	}
 }
 
 func (a *App) run() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	a.ctx = ctx
+
 	switch {
 	case a.args.Reset:
 		a.handleReset()
 	case a.args.Load != "":
 		a.handleLoadProfile()
+	case a.args.Command == "status":
+		a.handleStatus()
+	case a.args.Command == "dump":
+		a.handleDump()
+	case a.args.Command == "history":
+		a.handleHistory()
+	case a.args.Command == "raw":
+		a.handleRaw()
+	case a.args.Command == "calibrate":
+		a.handleCalibrate()
+	case a.args.Command == "diagnose":
+		a.handleDiagnose()
+	case a.args.Command == "selftest":
+		a.handleSelfTest()
+	case a.args.Command == "overlay":
+		a.handleOverlay()
 	default:
 		a.showHelp()
 	}
@@ -111,91 +166,29 @@ func (a *App) run() {
 
 func (a *App) handleReset() {
 	color.HiRed("Resetting device to default settings")
-	a.controller.WriteDefaults()
-	time.Sleep(10 * defaultWaitPerInstruction)
+	state, err := a.kb.Reset(a.ctx)
+	a.handleApplyError("Error resetting device", "(reset)", err)
+
+	entry := a.recordApplied("(reset)", newAppliedState(state))
+	a.runHooks(appliedEvent(a.serial, a.kb.Identity().KeyboardModel, "(reset)", entry))
 	color.White("Reset complete")
-	time.Sleep(defaultWaitPerInstruction)
 }
 
 func (a *App) handleLoadProfile() {
 	config := a.getConfig(a.args.Load)
-	if config.Model != "" && config.Model != a.controller.GetIdentity().KeyboardModel {
-		color.HiRed("Profile model does not match device model (expected %s, got %s)",
-			a.controller.GetIdentity().KeyboardModel, config.Model)
-		os.Exit(1)
-	}
-
-	debugPrintf("Model: %v | Turbo: %v | RT: %v | Default actuation: %v",
-		config.Model, config.Turbo, config.RapidTrigger.Enabled, config.DefaultActuation)
+	a.validateBeforeLoad(a.args.Load, config)
 
-	actuations, downstrokes, upstrokes := a.prepareKeySettings(config)
-
-	if !config.Light.Enabled {
-		config.Light.Sequence = driver.SEQUENCE_OFF
-	}
-
-	a.configureLights(config)
-	a.applySettings(config, actuations, downstrokes, upstrokes)
+	state, err := a.kb.Apply(a.ctx, config)
+	a.handleApplyError("Error loading profile", a.args.Load, err)
+	entry := a.recordApplied(a.args.Load, newAppliedState(state))
+	a.runHooks(appliedEvent(a.serial, a.kb.Identity().KeyboardModel, a.args.Load, entry))
+	a.warnNoisyActuations(state.Actuations)
 
 	color.White("Loaded %s%s%s",
 		color.GreenString(a.args.Load),
 		color.WhiteString(" for "),
-		color.HiBlueString("DrunkDeer %s", config.Model))
-	debugPrintf("Profile loaded")
-	time.Sleep(defaultWaitPerInstruction)
-}
-
-func (a *App) prepareKeySettings(config *Config) ([]byte, []byte, []byte) {
-	actuations := make([]byte, len(driver.KEYBOARD_LAYOUT))
-	downstrokes := make([]byte, len(driver.KEYBOARD_LAYOUT))
-	upstrokes := make([]byte, len(driver.KEYBOARD_LAYOUT))
-
-	defaultAct := driver.ActuationFloatToByte(config.DefaultActuation)
-	defaultDS := driver.ActuationFloatToByte(config.RapidTrigger.DefaultDownstroke)
-	defaultUS := driver.ActuationFloatToByte(config.RapidTrigger.DefaultUpstroke)
-
-	for i := range actuations {
-		actuations[i] = defaultAct
-		downstrokes[i] = defaultDS
-		upstrokes[i] = defaultUS
-	}
-
-	for key, value := range config.ActuationPoints {
-		i := driver.GetIndexByKey(key)
-		actuations[i] = driver.ActuationFloatToByte(value)
-	}
-
-	for key, value := range config.RapidTriggers {
-		i := driver.GetIndexByKey(key)
-		downstrokes[i] = driver.ActuationFloatToByte(value[0])
-		upstrokes[i] = driver.ActuationFloatToByte(value[1])
-	}
-
-	return actuations, downstrokes, upstrokes
-}
-
-func (a *App) configureLights(config *Config) {
-	a.controller.Light = &driver.DDLight{
-		Sequence:   byte(config.Light.Sequence),
-		Speed:      byte(config.Light.Speed),
-		Direction:  byte(config.Light.Direction),
-		Brightness: byte(config.Light.Brightness),
-	}
-}
-
-func (a *App) applySettings(config *Config, actuations, downstrokes, upstrokes []byte) {
-	a.controller.SendRapidTriggerTurbo(config.RapidTrigger.Enabled, config.Turbo)
-	a.controller.SendLEDModeSelect(
-		a.controller.Light.Direction,
-		a.controller.Light.Sequence,
-		a.controller.Light.Speed,
-		a.controller.Light.Brightness,
-		0xff,
-	)
-	a.controller.LoadActuations(actuations)
-	a.controller.LoadDownstrokes(downstrokes)
-	a.controller.LoadUpstrokes(upstrokes)
-	time.Sleep(10 * defaultWaitPerInstruction)
+		color.HiBlueString("DrunkDeer %s", a.kb.Identity().KeyboardModel))
+	logger.Info("profile loaded", slog.String("profile", a.args.Load), slog.String("device", a.serial))
 }
 
 func (a *App) showHelp() {
@@ -206,10 +199,27 @@ func (a *App) showHelp() {
 	color.HiBlue("List of commands")
 	color.White("For descriptions, run: drunkdeer --help")
 	color.HiWhite("  - drunkdeer import <url/path>")
-	color.HiWhite("  - drunkdeer load <profile>")
+	color.HiWhite("  - drunkdeer export <profile> [--format antler] [--model A75]")
+	color.HiWhite("  - drunkdeer load <profile>[+profile...]")
 	color.HiWhite("  - drunkdeer save <profile>")
+	color.HiWhite("  - drunkdeer validate <profile>")
+	color.HiWhite("  - drunkdeer show <profile> [--resolved]")
+	color.HiWhite("  - drunkdeer convert <profile> --to <json/jsonc/yaml/toml>")
+	color.HiWhite("  - drunkdeer migrate [profile]")
+	color.HiWhite("  - drunkdeer keys")
 	color.HiWhite("  - drunkdeer profiles")
 	color.HiWhite("  - drunkdeer reset")
 	color.HiWhite("  - drunkdeer list")
+	color.HiWhite("  - drunkdeer status")
+	color.HiWhite("  - drunkdeer dump")
+	color.HiWhite("  - drunkdeer history [24h/yesterday/2006-01-02]")
+	color.HiWhite("  - drunkdeer raw <hex> [--window 500ms] [--sweep 3:0x00-0xff]")
+	color.HiWhite("  - drunkdeer calibrate [--duration 2m]")
+	color.HiWhite("  - drunkdeer diagnose [--duration 2m]")
+	color.HiWhite("  - drunkdeer selftest")
+	color.HiWhite("  - drunkdeer daemon [--dbus]")
+	color.HiWhite("  - drunkdeer events")
+	color.HiWhite("  - drunkdeer serve [--listen 127.0.0.1:7342]")
+	color.HiWhite("  - drunkdeer overlay [--listen 127.0.0.1:7342] [--rate 30]")
 	color.HiWhite("  - drunkdeer version")
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+// Upgrades one profile, or every profile in the profile directory, to the current schema version. The old file
+// is kept next to it as <file>.v<version>.bak.
+func (a *App) handleMigrate(profile string) {
+	var paths []string
+	if profile != "" {
+		paths = []string{a.resolveProfilePath(profile)}
+	} else {
+		entries, err := os.ReadDir(a.profilePath)
+		handleError("Error reading profile directory", err)
+
+		for _, entry := range entries {
+			if !entry.IsDir() && keyboard.HasProfileExtension(entry.Name()) {
+				paths = append(paths, filepath.Join(a.profilePath, entry.Name()))
+			}
+		}
+	}
+
+	failed := 0
+	for _, path := range paths {
+		if err := migrateProfileFile(path); err != nil {
+			color.HiRed("%s: %v", path, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func migrateProfileFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	format := keyboard.DetectFormat(path, data)
+	version, err := keyboard.ProfileVersion(data, format)
+	if err != nil {
+		return err
+	}
+
+	if version == keyboard.SchemaVersion {
+		color.White("%s is up to date (version %d)", path, version)
+		return nil
+	}
+
+	migrated, from, err := keyboard.MigrateProfile(data, format)
+	if err != nil {
+		return err
+	}
+	if migrated == nil {
+		color.White("%s needs no changes, version %d loads as %d as it is", path, from, keyboard.SchemaVersion)
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, from)
+	if _, err := os.Stat(backup); err == nil {
+		return fmt.Errorf("backup %s already exists, not overwriting it", backup)
+	}
+
+	if err := os.WriteFile(backup, data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	if err := os.WriteFile(path, migrated, 0644); err != nil {
+		return err
+	}
+
+	color.HiGreen("%s upgraded from version %d to %d (backup in %s)", path, from, keyboard.SchemaVersion, filepath.Base(backup))
+	return nil
+}
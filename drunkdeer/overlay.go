@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	overlayWriteTimeout = 2 * time.Second
+	overlayClientBuffer = 4 // Frames a slow client can fall behind before it starts missing them
+)
+
+type overlayLayout struct {
+	Type    string   `json:"type"` // Always "layout", sent once per connection
+	Columns int      `json:"columns"`
+	Keys    []string `json:"keys"`
+}
+
+type overlayFrame struct {
+	Type    string    `json:"type"` // Always "travel"
+	Time    int64     `json:"time"` // Unix milliseconds
+	Depths  []float32 `json:"depths"`
+	Pressed []bool    `json:"pressed"`
+}
+
+type overlayHub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func (a *App) handleOverlay() {
+	if a.args.Rate <= 0 {
+		handleError("Error", fmt.Errorf("--rate must be positive"))
+	}
+
+	actuations, downstrokes, upstrokes, rt := a.appliedTables()
+	actuator := keyboard.NewActuator(actuations, downstrokes, upstrokes, rt)
+
+	travel, err := a.kb.TrackKeys(a.ctx)
+	handleError("Error starting key tracking", err)
+
+	assets, err := fs.Sub(webAssets, "web")
+	handleError("Error loading web assets", err)
+
+	hub := &overlayHub{clients: make(map[chan []byte]struct{})}
+	// Keeps other websites from reading keystrokes, also when they rebind their name to 127.0.0.1
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return allowedHost(r.Host, a.args.Listen) && (origin == "" || origin == "http://"+r.Host)
+	}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, assets, "overlay.html")
+	})
+	mux.HandleFunc("GET /travel", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Warn("websocket upgrade failed", slog.Any("err", err))
+			return
+		}
+		hub.serve(conn)
+	})
+
+	listener, err := net.Listen("tcp", a.args.Listen)
+	handleError("Error starting web server", err)
+
+	server := &http.Server{Handler: sameOrigin(a.args.Listen, mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-a.ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			handleError("Error serving", err)
+		}
+	}()
+
+	color.HiGreen("Overlay running on http://%s", listener.Addr())
+	color.White("Add it as a browser source, Ctrl+C to stop")
+
+	// Every snapshot goes through the actuator, the frame rate only limits what gets sent
+	ticker := time.NewTicker(time.Second / time.Duration(a.args.Rate))
+	defer ticker.Stop()
+
+	var latest *keyboard.Travel
+	changed := false
+	for {
+		select {
+		case t, ok := <-travel:
+			if !ok {
+				handleError("Key tracking stopped", fmt.Errorf("device disconnected"))
+			}
+			actuator.Update(t)
+			latest = &t
+			changed = true
+		case <-ticker.C:
+			if !changed {
+				continue
+			}
+			changed = false
+			hub.broadcast(newOverlayFrame(latest, actuator))
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+func newOverlayFrame(t *keyboard.Travel, actuator *keyboard.Actuator) overlayFrame {
+	frame := overlayFrame{
+		Type:    "travel",
+		Time:    t.At.UnixMilli(),
+		Depths:  make([]float32, len(driver.KEYBOARD_LAYOUT)),
+		Pressed: make([]bool, len(driver.KEYBOARD_LAYOUT)),
+	}
+
+	for i := range driver.KEYBOARD_LAYOUT {
+		frame.Depths[i] = t.DepthMM(i)
+		frame.Pressed[i] = actuator.Pressed(i)
+	}
+
+	return frame
+}
+
+func (h *overlayHub) broadcast(frame overlayFrame) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		logger.Warn("failed to encode overlay frame", slog.Any("err", err))
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		select {
+		case client <- data:
+		default:
+		}
+	}
+}
+
+func (h *overlayHub) serve(conn *websocket.Conn) {
+	defer conn.Close()
+
+	frames := make(chan []byte, overlayClientBuffer)
+	h.mu.Lock()
+	h.clients[frames] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, frames)
+		h.mu.Unlock()
+	}()
+
+	// The overlay never sends anything, reading is only how we notice it went away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(overlayWriteTimeout))
+	err := conn.WriteJSON(overlayLayout{Type: "layout", Columns: driver.LAYOUT_COLUMNS, Keys: driver.KEYBOARD_LAYOUT})
+	if err != nil {
+		return
+	}
+
+	for {
+		select {
+		case data := <-frames:
+			conn.SetWriteDeadline(time.Now().Add(overlayWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				logger.Debug("overlay client dropped", slog.Any("err", err))
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
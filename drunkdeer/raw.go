@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/fatih/color"
+)
+
+// Protocol research: send an arbitrary payload (report ID is added for you) and print whatever comes back
+func (a *App) handleRaw() {
+	payload, err := parseHexPayload(a.args.CmdValue)
+	handleError("Invalid payload", err)
+
+	if a.args.Sweep == "" {
+		a.sendRaw(payload)
+		return
+	}
+
+	offset, from, to, err := parseSweep(a.args.Sweep)
+	handleError("Invalid sweep", err)
+
+	if offset >= len(payload) {
+		// Let people sweep past the end without typing out the padding
+		payload = append(payload, make([]byte, offset-len(payload)+1)...)
+	}
+
+	for value := from; value <= to; value++ {
+		if a.ctx.Err() != nil {
+			return
+		}
+
+		payload[offset] = byte(value)
+		a.sendRaw(payload)
+	}
+}
+
+func (a *App) sendRaw(payload []byte) {
+	controller := a.kb.Controller()
+	packets, unsubscribe := controller.Subscribe()
+	defer unsubscribe()
+
+	color.HiBlue("-> %x", payload)
+	handleError("Error queueing packet", controller.QueuePacket(append([]byte(nil), payload...)))
+	handleError("Error sending packet", controller.Flush(a.ctx))
+
+	deadline := time.After(a.args.Window)
+	for {
+		select {
+		case p := <-packets:
+			fmt.Printf("%s %02x %x  %s\n",
+				color.HiGreenString("<-"),
+				p.Packet,
+				p.Data,
+				color.RGB(0x80, 0x80, 0x80).Sprint(driver.DescribePacket(p)))
+		case <-deadline:
+			return
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+// Accepts "a002", "a0 02", "a0:02" or "0xa0,0x02"
+func parseHexPayload(value string) ([]byte, error) {
+	cleaned := strings.NewReplacer(" ", "", ":", "", ",", "", "0x", "", "0X", "").Replace(value)
+	if cleaned == "" {
+		return nil, fmt.Errorf("empty payload")
+	}
+
+	payload, err := hex.DecodeString(cleaned)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payload) > 63 {
+		return nil, fmt.Errorf("payload is %d bytes, reports carry at most 63", len(payload))
+	}
+
+	return payload, nil
+}
+
+// Format is offset:from-to, e.g. 3:0x00-0x1f
+func parseSweep(value string) (int, int, int, error) {
+	offsetStr, rangeStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("expected offset:from-to, got %q", value)
+	}
+
+	fromStr, toStr, ok := strings.Cut(rangeStr, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("expected offset:from-to, got %q", value)
+	}
+
+	offset, err := strconv.ParseUint(offsetStr, 0, 8)
+	if err != nil || offset > 62 {
+		return 0, 0, 0, fmt.Errorf("offset must be between 0 and 62, got %q", offsetStr)
+	}
+
+	from, err := strconv.ParseUint(fromStr, 0, 8)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid range start %q", fromStr)
+	}
+
+	to, err := strconv.ParseUint(toStr, 0, 8)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid range end %q", toStr)
+	}
+
+	if from > to {
+		return 0, 0, 0, fmt.Errorf("range start is after its end")
+	}
+
+	return int(offset), int(from), int(to), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+)
+
+// JSON-RPC 2.0, one message per line over the daemon's unix socket
+const (
+	daemonSocketName  = "daemon.sock"
+	daemonDialTimeout = 200 * time.Millisecond
+
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// IDs can be numbers or strings and are echoed back as sent. No id at all makes it a notification.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+var rpcNullID = json.RawMessage("null")
+
+// Responses always carry an id, null when the request couldn't be read. Notifications have none.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"` // Only set on notifications
+	Params  any             `json:"params,omitempty"` // Only set on notifications
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// Only strings, numbers and null are allowed as ids
+func validRPCID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+
+	switch id[0] {
+	case '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'n':
+		return true
+	}
+	return false
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type deviceParams struct {
+	Index int `json:"index"`
+}
+
+type loadParams struct {
+	Index   int    `json:"index"`
+	Profile string `json:"profile"`
+}
+
+type setParams struct {
+	Index           int                     `json:"index"`
+	Turbo           *bool                   `json:"turbo,omitempty"`
+	RapidTrigger    *bool                   `json:"rapidTrigger,omitempty"`
+	Light           *keyboard.LightSettings `json:"light,omitempty"`
+	ActuationPoints map[string]float32      `json:"actuationPoints,omitempty"`
+	RapidTriggers   map[string][2]float32   `json:"rapidTriggers,omitempty"`
+}
+
+type deviceStatus struct {
+	Index    int           `json:"index"`
+	Serial   string        `json:"serial"`
+	Model    string        `json:"model"`
+	Firmware string        `json:"firmware"`
+	Applied  *JournalEntry `json:"applied,omitempty"`
+}
+
+type deviceEvent struct {
+	Type      string    `json:"type"` // connected, disconnected, applied, failed, unknown-model
+	Serial    string    `json:"serial"`
+	Model     string    `json:"model,omitempty"`
+	ModelID   string    `json:"modelId,omitempty"` // Hex model bytes, only on unknown-model
+	Profile   string    `json:"profile,omitempty"`
+	StateHash string    `json:"stateHash,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func daemonSocketPath(profileDir string) string {
+	return filepath.Join(profileDir, daemonSocketName)
+}
+
+type daemonClient struct {
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	nextID  int64
+}
+
+// Returns nil if no daemon is listening
+func dialDaemon(profileDir string) *daemonClient {
+	conn, err := net.DialTimeout("unix", daemonSocketPath(profileDir), daemonDialTimeout)
+	if err != nil {
+		return nil
+	}
+
+	return &daemonClient{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+		decoder: json.NewDecoder(conn),
+	}
+}
+
+func (c *daemonClient) call(method string, params any, result any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.nextID++
+	id := json.RawMessage(strconv.FormatInt(c.nextID, 10))
+	if err := c.encoder.Encode(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: raw}); err != nil {
+		return fmt.Errorf("failed to talk to daemon: %w", err)
+	}
+
+	for {
+		var resp rpcResponse
+		if err := c.decoder.Decode(&resp); err != nil {
+			return fmt.Errorf("failed to read daemon response: %w", err)
+		}
+
+		// Skip notifications from an earlier subscribe
+		if !bytes.Equal(resp.ID, id) {
+			continue
+		}
+
+		if resp.Error != nil {
+			return resp.Error
+		}
+
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	}
+}
+
+// Blocks, calling fn for every event until the connection drops
+func (c *daemonClient) subscribe(fn func(deviceEvent)) error {
+	if err := c.call("subscribe", nil, nil); err != nil {
+		return err
+	}
+
+	for {
+		var msg struct {
+			Method string      `json:"method"`
+			Params deviceEvent `json:"params"`
+		}
+		if err := c.decoder.Decode(&msg); err != nil {
+			return err
+		}
+
+		if msg.Method == "event" {
+			fn(msg.Params)
+		}
+	}
+}
+
+func (c *daemonClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+func (a *App) handleSelfTest() {
+	identity := a.kb.Identity()
+	color.HiBlue("Testing DrunkDeer %s (firmware v%s)", identity.KeyboardModel, identity.FirmwareVersion)
+
+	checks := a.kb.SelfTest(a.ctx)
+	failed := showSelfTestMatrix(checks)
+
+	a.restoreAfterSelfTest()
+
+	if failed > 0 {
+		color.HiRed("%d of %d checks failed", failed, len(checks))
+		for _, check := range checks {
+			if !check.Passed() {
+				fmt.Printf("  %s: %v\n", selfTestCheckName(&check), check.Err)
+			}
+		}
+		os.Exit(1)
+	}
+
+	color.HiGreen("All %d checks passed", len(checks))
+}
+
+// Returns the number of failed checks
+func showSelfTestMatrix(checks []keyboard.SelfTestCheck) int {
+	failed := 0
+	cell := func(check *keyboard.SelfTestCheck) string {
+		if check.Passed() {
+			return color.HiGreenString("%-6s", "PASS")
+		}
+		failed++
+		return color.HiRedString("%-6s", "FAIL")
+	}
+
+	// Table rows go into a table-by-row grid, everything else is one line each
+	grid := make(map[string][]string)
+	order := make([]string, 0)
+	for i := range checks {
+		check := &checks[i]
+		if check.Row < 0 {
+			fmt.Printf("%-12s %s\n", check.Name, cell(check))
+			continue
+		}
+
+		if _, ok := grid[check.Name]; !ok {
+			order = append(order, check.Name)
+		}
+		grid[check.Name] = append(grid[check.Name], cell(check))
+	}
+
+	if len(order) > 0 {
+		fmt.Printf("\n%-12s", "")
+		for row := range grid[order[0]] {
+			fmt.Printf(" %-6s", fmt.Sprintf("row%d", row))
+		}
+		fmt.Println()
+
+		for _, name := range order {
+			fmt.Printf("%-12s", name)
+			for _, c := range grid[name] {
+				fmt.Printf(" %s", c)
+			}
+			fmt.Println()
+		}
+	}
+
+	return failed
+}
+
+func selfTestCheckName(check *keyboard.SelfTestCheck) string {
+	if check.Row < 0 {
+		return check.Name
+	}
+	return fmt.Sprintf("%s row %d", check.Name, check.Row)
+}
+
+// The self test leaves test values behind, put back whatever the journal says was loaded
+func (a *App) restoreAfterSelfTest() {
+	entries, err := readJournal(a.journalPath())
+	if err != nil || len(entries) == 0 {
+		color.White("Restoring firmware defaults")
+		_, err := a.kb.Reset(a.ctx)
+		handleError("Error restoring defaults", err)
+		return
+	}
+
+	entry := entries[len(entries)-1]
+	color.White("Restoring %s", color.GreenString(entry.Profile))
+	handleError("Error restoring previous state", a.kb.ApplyState(a.ctx, entry.State.toKeyboardState()))
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+// Everything the UI needs is in the binary, no vendor servers involved
+//
+//go:embed web
+var webAssets embed.FS
+
+const maxProfileSize = 1 << 20
+
+type layoutResponse struct {
+	Columns          int      `json:"columns"`
+	Keys             []string `json:"keys"`
+	DefaultActuation float32  `json:"defaultActuation"`
+}
+
+type configurator struct {
+	app *App
+	ctx context.Context
+	mu  sync.Mutex // One apply at a time
+}
+
+func (a *App) runServe() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	assets, err := fs.Sub(webAssets, "web")
+	handleError("Error loading web assets", err)
+
+	c := &configurator{app: a, ctx: ctx}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(assets))
+	mux.HandleFunc("GET /api/layout", c.handleLayout)
+	mux.HandleFunc("GET /api/profiles", c.handleListProfiles)
+	mux.HandleFunc("GET /api/profiles/{name}", c.handleGetProfile)
+	mux.HandleFunc("PUT /api/profiles/{name}", c.handleSaveProfile)
+	mux.HandleFunc("POST /api/profiles/{name}/apply", c.handleApplyProfile)
+
+	listener, err := net.Listen("tcp", a.args.Listen)
+	handleError("Error starting web server", err)
+
+	server := &http.Server{Handler: sameOrigin(a.args.Listen, mux), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	color.HiGreen("Configurator running on http://%s", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		handleError("Error serving", err)
+	}
+}
+
+func (c *configurator) handleLayout(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, layoutResponse{
+		Columns:          driver.LAYOUT_COLUMNS,
+		Keys:             driver.KEYBOARD_LAYOUT,
+		DefaultActuation: float32(driver.DEFAULT_ACTUATION) / 10,
+	})
+}
+
+func (c *configurator) handleListProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := listProfiles(c.app.profilePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+func (c *configurator) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	path, err := c.profileFile(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no profile named %s", r.PathValue("name")))
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	format := keyboard.DetectFormat(path, data)
+	profile, err := keyboard.ParseProfileFormat(data, format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Shown with everything it inherits, "extends" stays so saving writes back only what differs
+	if profile.Extends != "" {
+		resolved, err := keyboard.ResolveProfile(data, format, path)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resolved.Extends = profile.Extends
+		profile = resolved
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (c *configurator) handleSaveProfile(w http.ResponseWriter, r *http.Request) {
+	path, err := c.profileFile(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxProfileSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	profile, err := keyboard.ParseProfileFormat(data, keyboard.FormatJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Checked before resolving, which would fetch URLs and read files anywhere
+	if err := c.checkExtends(path, profile.Extends); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Catches unknown keys before they end up on disk, groups may come from the profile it extends
+	resolved, err := keyboard.ResolveProfile(data, keyboard.FormatJSON, path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, _, _, err := resolved.Tables(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := c.app.writeConfigToFile(profile, path); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logger.Info("profile saved", slog.String("path", path))
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (c *configurator) handleApplyProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, err := c.profileFile(name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, err := c.app.applyProfile(c.ctx, name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// Profile names come from the URL, they must not escape the profile directory
+func (c *configurator) profileFile(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+
+	return keyboard.FindProfile(filepath.Join(c.app.profilePath, name)), nil
+}
+
+// Pages can only extend other profiles in the directory, the CLI still takes URLs and absolute paths
+func (c *configurator) checkExtends(path, extends string) error {
+	if extends == "" {
+		return nil
+	}
+	if keyboard.IsURL(extends) || filepath.IsAbs(extends) {
+		return fmt.Errorf("extends %q must be a profile in %s", extends, c.app.profilePath)
+	}
+
+	rel, err := filepath.Rel(c.app.profilePath, filepath.Join(filepath.Dir(path), extends))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("extends %q must be a profile in %s", extends, c.app.profilePath)
+	}
+	return nil
+}
+
+// Goes through the daemon when one is running, otherwise opens the keyboard just for this
+func (a *App) applyProfile(ctx context.Context, name string) (*JournalEntry, error) {
+	if !a.args.NoDaemon {
+		if client := dialDaemon(a.profilePath); client != nil {
+			defer client.Close()
+
+			var entry JournalEntry
+			if err := client.call("load", loadParams{Index: a.keyboardIndex, Profile: name}, &entry); err != nil {
+				return nil, err
+			}
+			return &entry, nil
+		}
+	}
+
+	profile, err := a.loadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+
+	kb, err := keyboard.OpenIndex(a.keyboardIndex, keyboard.WithLogger(logger))
+	if err != nil {
+		return nil, err
+	}
+	defer kb.Close()
+
+	serial, model := deviceSerial(kb), kb.Identity().KeyboardModel
+	a.checkUnknownModel(kb, serial)
+
+	state, err := kb.Apply(ctx, profile)
+	if err != nil {
+		a.runHooks(failedEvent(serial, model, name, err))
+		return nil, err
+	}
+
+	entry, err := journalApplied(a.profilePath, serial, kb.Identity(), name, newAppliedState(state))
+	if err != nil {
+		return nil, err
+	}
+
+	a.runHooks(appliedEvent(serial, model, name, entry))
+	return entry, nil
+}
+
+// Any website open in the browser can send requests to localhost, only let the UI itself change things
+func sameOrigin(listen string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, listen) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("unexpected host %s", r.Host))
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if r.Method != http.MethodGet && origin != "" && origin != "http://"+r.Host {
+			writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %s", origin))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DNS rebinding points a website's own name at 127.0.0.1, which makes it the same origin as far as the browser
+// is concerned. Only names that can't be rebound are served: localhost, loopback addresses and the --listen host,
+// or any address when listening on all interfaces.
+func allowedHost(host, listen string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	listenHost, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		listenHost = listen
+	}
+
+	if strings.EqualFold(host, "localhost") || (listenHost != "" && strings.EqualFold(host, listenHost)) {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+
+	listenIP := net.ParseIP(listenHost)
+	return listenHost == "" || (listenIP != nil && listenIP.IsUnspecified())
+}
+
+func listProfiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The same name in two formats is listed once, loading it picks the first in ProfileExtensions order
+	profiles := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !keyboard.HasProfileExtension(entry.Name()) {
+			continue
+		}
+
+		name := keyboard.TrimProfileExtension(entry.Name())
+		if !slices.Contains(profiles, name) {
+			profiles = append(profiles, name)
+		}
+	}
+
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	logger.Warn("request failed", slog.Int("status", status), slog.Any("err", err))
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
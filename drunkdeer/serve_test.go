@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host, listen string
+		want         bool
+	}{
+		{"127.0.0.1:7342", "127.0.0.1:7342", true},
+		{"localhost:7342", "127.0.0.1:7342", true},
+		{"[::1]:7342", "127.0.0.1:7342", true},
+		{"evil.example:7342", "127.0.0.1:7342", false},
+		{"192.168.1.5:7342", "127.0.0.1:7342", false},
+		{"192.168.1.5:7342", "192.168.1.5:7342", true},
+		{"desk.lan:7342", "desk.lan:7342", true},
+		{"192.168.1.5:7342", "0.0.0.0:7342", true},
+		{"192.168.1.5:7342", ":7342", true},
+		{"evil.example:7342", "0.0.0.0:7342", false},
+		{"evil.example", ":7342", false},
+	}
+
+	for _, test := range tests {
+		if got := allowedHost(test.host, test.listen); got != test.want {
+			t.Errorf("host %s listening on %s: got %v, expected %v", test.host, test.listen, got, test.want)
+		}
+	}
+}
+
+func TestConfiguratorKeepsInheritance(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.json": `{"model": "A75", "turbo": true, "light": {"enabled": true, "speed": 5, "brightness": 7},
+			"actuationPoints": {"W": 0.5, "TAB": 3.0}}`,
+		"game.json": `{"extends": "base", "light": {"speed": 9}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &configurator{app: &App{profilePath: dir}}
+
+	get := httptest.NewRequest(http.MethodGet, "/api/profiles/game", nil)
+	get.SetPathValue("name", "game")
+	got := httptest.NewRecorder()
+	c.handleGetProfile(got, get)
+
+	var shown keyboard.Profile
+	if err := json.Unmarshal(got.Body.Bytes(), &shown); err != nil {
+		t.Fatalf("%v: %s", err, got.Body)
+	}
+	if shown.Extends != "base" || shown.Model != "A75" || shown.Light.Speed != 9 || shown.Light.Brightness != 7 {
+		t.Fatalf("expected the resolved profile, got %+v", shown)
+	}
+
+	// Saved back as the UI does, with one key override dropped
+	delete(shown.ActuationPoints, "TAB")
+	body, _ := json.Marshal(&shown)
+	put := httptest.NewRequest(http.MethodPut, "/api/profiles/game", bytes.NewReader(body))
+	put.SetPathValue("name", "game")
+	saved := httptest.NewRecorder()
+	c.handleSaveProfile(saved, put)
+	if saved.Code != http.StatusOK {
+		t.Fatalf("save failed: %s", saved.Body)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "game.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written map[string]any
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"schemaVersion":   float64(keyboard.SchemaVersion),
+		"extends":         "base",
+		"light":           map[string]any{"speed": float64(9)},
+		"actuationPoints": map[string]any{"TAB": nil},
+	}
+	if !reflect.DeepEqual(written, want) {
+		t.Fatalf("expected only the changes to be written, got %s", data)
+	}
+
+	// Still follows the parent
+	os.WriteFile(filepath.Join(dir, "base.json"), []byte(`{"model": "G65", "light": {"brightness": 2}}`), 0644)
+	profile, err := keyboard.LoadProfile(filepath.Join(dir, "game.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Model != "G65" || profile.Light.Brightness != 2 || profile.Light.Speed != 9 {
+		t.Errorf("changes to the parent don't come through: %+v", profile)
+	}
+}
+
+func TestConfiguratorRejectsOutsideExtends(t *testing.T) {
+	dir := t.TempDir()
+	c := &configurator{app: &App{profilePath: dir}}
+
+	for _, extends := range []string{"https://example.com/base.json", "/etc/passwd", "../base", "sub/../../base"} {
+		body, _ := json.Marshal(map[string]any{"extends": extends})
+		put := httptest.NewRequest(http.MethodPut, "/api/profiles/game", bytes.NewReader(body))
+		put.SetPathValue("name", "game")
+		got := httptest.NewRecorder()
+		c.handleSaveProfile(got, put)
+
+		if got.Code != http.StatusBadRequest {
+			t.Errorf("extends %q: expected 400, got %d %s", extends, got.Code, got.Body)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "game.json")); !os.IsNotExist(err) {
+		t.Errorf("nothing should have been written, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+)
+
+// Prints a profile as written, or with --resolved as it would be loaded with everything it extends merged in
+func (a *App) handleShow(profilePath string) {
+	if profilePath == "" {
+		handleError("Error", fmt.Errorf("usage: drunkdeer show <profile> [--resolved]"))
+	}
+
+	if !a.args.Resolved {
+		source := profilePath
+		if !keyboard.IsURL(source) {
+			source = a.resolveProfilePath(source)
+		}
+
+		data, err := keyboard.ReadProfile(source)
+		handleError("Failed to read profile", err)
+
+		os.Stdout.Write(data)
+		os.Exit(0)
+	}
+
+	config, err := a.loadConfig(profilePath)
+	handleError("Failed to load profile", err)
+
+	data, err := json.MarshalIndent(config, "", "    ")
+	handleError("Failed to encode profile", err)
+
+	fmt.Println(string(data))
+	os.Exit(0)
+}
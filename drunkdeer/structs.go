@@ -1,34 +1,15 @@
 package main
 
-type RapidTriggerSettings struct {
-	Enabled           bool    `json:"enabled"`
-	DefaultDownstroke float32 `json:"defaultDownstroke"`
-	DefaultUpstroke   float32 `json:"defaultUpstroke"`
-}
-
-type LightSettings struct {
-	Enabled    bool `json:"enabled"`
-	Direction  int  `json:"direction"`
-	Sequence   int  `json:"sequence"`
-	Speed      int  `json:"speed"`
-	Brightness int  `json:"brightness"`
-}
-
-type Config struct {
-	Model            string                `json:"model"`
-	RapidTrigger     RapidTriggerSettings  `json:"rapidTrigger"`
-	Turbo            bool                  `json:"turbo"`
-	DefaultActuation float32               `json:"defaultActuation"`
-	ActuationPoints  map[string]float32    `json:"actuationPoints"`
-	RapidTriggers    map[string][2]float32 `json:"rapidTriggers"`
-	Light            LightSettings         `json:"light"`
-}
+import "time"
 
 type Args struct {
 	Command  string `arg:"positional"`
 	CmdValue string `arg:"positional"`
 	Import   string `arg:"-i,--import" help:"Import a drunkdeer webdriver profile from the specified file/url"`
-	Debug    bool   `arg:"-d,--debug" help:"Enable debug mode"`
+	Debug    bool   `arg:"-d,--debug" help:"Enable debug mode (same as --log-level debug)"`
+	LogLevel string `arg:"--log-level" default:"warn" help:"Log level: debug, info, warn or error"`
+	LogJSON  bool   `arg:"--log-json" help:"Write logs as JSON"`
+	LogFile  string `arg:"--log-file" help:"Write logs to the specified file instead of stderr"`
 	Index    int    `arg:"-i,--index" help:"Keyboard index to use (0 for first device, 1 for second, etc.)"`
 	Profiles bool   `arg:"-p,--profiles" help:"Show all available profiles"`
 	Reset    bool   `arg:"-r,--reset" help:"Reset the keyboard to default settings"`
@@ -36,4 +17,18 @@ type Args struct {
 	Save     string `arg:"-S,--save" help:"Save URL as profile"`
 	Version  bool   `arg:"-v,--version" help:"Show version information"`
 	List     bool   `arg:"-l,--list" help:"List all connected devices"`
+	NoDaemon bool   `arg:"--no-daemon" help:"Talk to the keyboard directly even if a daemon is running"`
+	DBus     bool   `arg:"--dbus" help:"daemon: also serve org.drunkdeer.Keyboard on the D-Bus session bus"`
+	Resolved bool   `arg:"--resolved" help:"show: print the profile with everything it extends merged in"`
+	To       string `arg:"--to" help:"convert: profile format to convert to (json, jsonc, yaml or toml)"`
+	Format   string `arg:"--format" default:"antler" help:"export: format to export to (antler, the vendor web driver)"`
+	Model    string `arg:"--model" help:"export: keyboard model for profiles that don't set one (A75, G75, G65 or G60)"`
+
+	Window time.Duration `arg:"--window" default:"500ms" help:"raw: how long to collect replies after each packet"`
+	Sweep  string        `arg:"--sweep" help:"raw: iterate one payload byte through a range, as offset:from-to (e.g. 3:0x00-0x1f)"`
+
+	Listen string `arg:"--listen" default:"127.0.0.1:7342" help:"serve/overlay: address to listen on"`
+	Rate   int    `arg:"--rate" default:"30" help:"overlay: key travel frames per second"`
+
+	Duration time.Duration `arg:"--duration" default:"2m" help:"calibrate/diagnose: how long to wait for key presses"`
 }
@@ -1,19 +1,21 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/2xxn/cli-drunkdeer/keyboard"
 	"github.com/fatih/color"
 	"github.com/sstallion/go-hid"
 )
 
+const profileLayerSeparator = "+"
+
 func copyFile(src, dst string) error {
 	source, err := os.Open(src)
 	if err != nil {
@@ -34,78 +36,42 @@ func copyFile(src, dst string) error {
 	return destination.Sync()
 }
 
-func download(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch profile from URL: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	return body, nil
-}
-
-func isURL(path string) bool {
-	return strings.HasPrefix(path, "http") && strings.Contains(path, "://")
-}
-
-func getDevice(index int) (*hid.Device, error) {
-	devices := FindDrunkDeerDevices()
-	if len(devices) == 0 {
-		return nil, fmt.Errorf("no devices found")
-	}
-
-	if index < 0 || index >= len(devices) {
-		return nil, fmt.Errorf("invalid keyboard index: %d", index)
-	}
-
-	device, err := hid.OpenPath(devices[index].Path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open device: %w", err)
-	}
-
-	return device, nil
-}
-
 func grabDeviceIdentity(deviceInfo *hid.DeviceInfo) (*driver.DDKeyboardIdentity, error) {
-	device, err := hid.OpenPath(deviceInfo.Path)
+	kb, err := keyboard.Open(deviceInfo, keyboard.WithLogger(logger))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open device for identity: %w", err)
 	}
-	defer device.Close()
+	defer kb.Close()
 
-	controller := driver.NewDrunkDeerController(device)
-	return controller.GetIdentity(), nil
+	return kb.Identity(), nil
 }
 
-func (a *App) getConfig(loadPath string) *Config {
-	debugPrintf("Loading profile from %s", loadPath)
+func (a *App) getConfig(loadPath string) *keyboard.Profile {
+	config, err := a.loadConfig(loadPath)
+	handleError("Failed to load profile", err)
+
+	return config
+}
 
-	var config Config
-	var data []byte
-	var err error
+// Several profiles joined with + (base+wasd-low+lights-off) are layered left to right
+func (a *App) loadConfig(loadPath string) (*keyboard.Profile, error) {
+	logger.Debug("loading profile", slog.String("path", loadPath))
 
-	if isURL(loadPath) {
-		data, err = download(loadPath)
-		handleError("Failed to download profile", err)
-	} else {
-		profilePath := a.resolveProfilePath(loadPath)
-		data, err = os.ReadFile(profilePath)
-		handleError("Failed to read profile file", err)
+	sources := []string{loadPath}
+	if _, err := os.Stat(a.resolveProfilePath(loadPath)); err != nil && !keyboard.IsURL(loadPath) {
+		sources = strings.Split(loadPath, profileLayerSeparator)
 	}
 
-	err = json.Unmarshal(data, &config)
-	handleError("Failed to parse profile JSON", err)
+	for i, source := range sources {
+		if source == "" {
+			return nil, fmt.Errorf("empty profile name in %q", loadPath)
+		}
+		if !keyboard.IsURL(source) {
+			sources[i] = a.resolveProfilePath(source)
+		}
+	}
 
-	return &config
+	return keyboard.LoadProfiles(sources...)
 }
 
 func (a *App) resolveProfilePath(loadPath string) string {
@@ -113,12 +79,7 @@ func (a *App) resolveProfilePath(loadPath string) string {
 		return loadPath
 	}
 
-	profilePath := filepath.Join(a.profilePath, loadPath)
-	if !strings.HasSuffix(profilePath, ".json") {
-		profilePath += ".json"
-	}
-
-	return profilePath
+	return keyboard.FindProfile(filepath.Join(a.profilePath, loadPath))
 }
 
 func handleError(message string, err error) {
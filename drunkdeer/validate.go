@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/2xxn/cli-drunkdeer/keyboard"
+	"github.com/fatih/color"
+)
+
+func (a *App) handleValidate(profilePath string) {
+	if profilePath == "" {
+		handleError("Error", fmt.Errorf("usage: drunkdeer validate <profile>"))
+	}
+
+	config, err := a.loadConfig(profilePath)
+	handleError("Invalid profile", err)
+
+	issues := config.Validate(a.connectedModel())
+	if len(issues) == 0 {
+		color.HiGreen("%s is valid", profilePath)
+		os.Exit(0)
+	}
+
+	printValidation(issues)
+	if len(keyboard.Errors(issues)) > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Model of the selected keyboard, for the mismatch check and exports, empty if none is plugged in
+func (a *App) connectedModel() string {
+	if !a.args.NoDaemon {
+		if client := dialDaemon(a.profilePath); client != nil {
+			defer client.Close()
+
+			var status deviceStatus
+			if err := client.call("status", deviceParams{Index: a.keyboardIndex}, &status); err == nil {
+				return status.Model
+			}
+			return ""
+		}
+	}
+
+	devices := keyboard.FindDrunkDeerDevices()
+	if a.keyboardIndex >= len(devices) {
+		return ""
+	}
+
+	identity, err := grabDeviceIdentity(&devices[a.keyboardIndex])
+	if err != nil {
+		return ""
+	}
+	return identity.KeyboardModel
+}
+
+func printValidation(issues []keyboard.ValidationIssue) {
+	errors := 0
+	for _, issue := range issues {
+		if issue.Warning {
+			fmt.Printf("%s %s\n", color.HiYellowString("warning"), issue)
+			continue
+		}
+
+		errors++
+		fmt.Printf("%s   %s\n", color.HiRedString("error"), issue)
+	}
+
+	if errors > 0 {
+		color.HiRed("%d errors, %d warnings", errors, len(issues)-errors)
+	}
+}
+
+// Run before sending anything, so a broken profile never half-applies
+func (a *App) validateBeforeLoad(profile string, config *keyboard.Profile) {
+	issues := config.Validate(a.kb.Identity().KeyboardModel)
+	if len(issues) == 0 {
+		return
+	}
+
+	printValidation(issues)
+	if errs := keyboard.Errors(issues); len(errs) > 0 {
+		a.handleApplyError("Not loading "+profile, profile, &keyboard.ValidationError{Issues: errs})
+	}
+}
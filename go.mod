@@ -2,12 +2,18 @@ module github.com/2xxn/cli-drunkdeer
 
 go 1.23.0
 
-require github.com/sstallion/go-hid v0.14.1
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/alexflint/go-arg v1.5.1
+	github.com/fatih/color v1.18.0
+	github.com/godbus/dbus/v5 v5.2.2
+	github.com/gorilla/websocket v1.5.3
+	github.com/sstallion/go-hid v0.14.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/alexflint/go-arg v1.5.1 // indirect
 	github.com/alexflint/go-scalar v1.2.0 // indirect
-	github.com/fatih/color v1.18.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	golang.org/x/sys v0.31.0 // indirect
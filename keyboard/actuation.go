@@ -0,0 +1,87 @@
+package keyboard
+
+import "github.com/2xxn/cli-drunkdeer/driver"
+
+type actuatorKey struct {
+	pressed bool
+	extreme byte // Peak while pressed, trough while released (rapid trigger reference)
+}
+
+// Actuator replays key depths through the actuation and rapid trigger logic to tell which keys are down,
+// the keyboard doesn't report that itself
+type Actuator struct {
+	actuations   []byte
+	downstrokes  []byte
+	upstrokes    []byte
+	rapidTrigger bool
+
+	keys []actuatorKey
+}
+
+// Tables in 0.1mm units like the ones sent to the keyboard
+func NewActuator(actuations, downstrokes, upstrokes []byte, rapidTrigger bool) *Actuator {
+	return &Actuator{
+		actuations:   actuations,
+		downstrokes:  downstrokes,
+		upstrokes:    upstrokes,
+		rapidTrigger: rapidTrigger,
+		keys:         make([]actuatorKey, len(driver.KEYBOARD_LAYOUT)),
+	}
+}
+
+// Step advances the key's state machine, returns whether it actuated
+func (a *Actuator) Step(i int, depth byte) bool {
+	key := &a.keys[i]
+	actuation := tableAt(a.actuations, i, driver.DEFAULT_ACTUATION)
+
+	if depth < actuation {
+		key.pressed = false
+		key.extreme = depth
+		return false
+	}
+
+	if !a.rapidTrigger {
+		if key.pressed {
+			return false
+		}
+		key.pressed = true
+		return true
+	}
+
+	// Past the actuation point rapid trigger decides, relative to the last peak/trough
+	if key.pressed {
+		if depth > key.extreme {
+			key.extreme = depth
+		} else if key.extreme-depth >= max(tableAt(a.upstrokes, i, 1), 1) {
+			key.pressed = false
+			key.extreme = depth
+		}
+		return false
+	}
+
+	if depth < key.extreme {
+		key.extreme = depth
+		return false
+	}
+
+	if key.extreme >= actuation && depth-key.extreme < max(tableAt(a.downstrokes, i, 1), 1) {
+		return false
+	}
+
+	key.pressed = true
+	key.extreme = depth
+	return true
+}
+
+// Update steps every key with a tracking snapshot
+func (a *Actuator) Update(t Travel) {
+	for i, depth := range t.Depths {
+		if i < len(a.keys) {
+			a.Step(i, depth)
+		}
+	}
+}
+
+func (a *Actuator) Pressed(i int) bool {
+	return i >= 0 && i < len(a.keys) && a.keys[i].pressed
+}
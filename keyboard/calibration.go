@@ -0,0 +1,215 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// Thresholds for flagging a sensor, in mm
+const (
+	CalibrationMaxNoise     = 0.2
+	CalibrationMaxOffset    = 0.2
+	CalibrationMinMaxTravel = 3.4 // The switches bottom out at 4.0mm
+)
+
+type KeyCalibration struct {
+	Key       string  `json:"key"`
+	Index     int     `json:"index"`
+	Rest      float32 `json:"rest"`      // Average reading while idle
+	Noise     float32 `json:"noise"`     // Spread of readings while idle
+	MaxTravel float32 `json:"maxTravel"` // Deepest reading seen while pressing
+	Pressed   bool    `json:"pressed"`
+}
+
+type Calibration struct {
+	Serial    string           `json:"serial"`
+	Model     string           `json:"model"`
+	Timestamp time.Time        `json:"timestamp"`
+	Keys      []KeyCalibration `json:"keys"`
+}
+
+type CalibrationIssue struct {
+	Key    string
+	Reason string
+}
+
+// Accumulates tracking snapshots, first while every key rests, then while each one is pressed
+type Calibrator struct {
+	idleMin   []byte
+	idleMax   []byte
+	idleSum   []int
+	idleCount []int
+	max       []byte
+}
+
+func NewCalibrator() *Calibrator {
+	n := len(driver.KEYBOARD_LAYOUT)
+	c := &Calibrator{
+		idleMin:   make([]byte, n),
+		idleMax:   make([]byte, n),
+		idleSum:   make([]int, n),
+		idleCount: make([]int, n),
+		max:       make([]byte, n),
+	}
+
+	for i := range c.idleMin {
+		c.idleMin[i] = 0xff
+	}
+
+	return c
+}
+
+// Only the row in the update counts as a reading, the other rows would repeat old readings or be zeros
+func (c *Calibrator) Idle(t Travel) {
+	start, end := t.RowKeys()
+	for i := start; i < end; i++ {
+		depth := t.Depths[i]
+		c.idleMin[i] = min(c.idleMin[i], depth)
+		c.idleMax[i] = max(c.idleMax[i], depth)
+		c.idleSum[i] += int(depth)
+		c.idleCount[i]++
+	}
+}
+
+func (c *Calibrator) Press(t Travel) {
+	for i, depth := range t.Depths {
+		c.max[i] = max(c.max[i], depth)
+	}
+}
+
+// Keys that haven't been fully pressed yet
+func (c *Calibrator) Remaining() []string {
+	remaining := make([]string, 0)
+	for i, key := range driver.KEYBOARD_LAYOUT {
+		if key != "" && float32(c.max[i])/10 < CalibrationMinMaxTravel {
+			remaining = append(remaining, key)
+		}
+	}
+	return remaining
+}
+
+func (c *Calibrator) Result(serial, model string) *Calibration {
+	calibration := &Calibration{
+		Serial:    serial,
+		Model:     model,
+		Timestamp: time.Now(),
+		Keys:      make([]KeyCalibration, 0),
+	}
+
+	for i, key := range driver.KEYBOARD_LAYOUT {
+		if key == "" {
+			continue
+		}
+
+		kc := KeyCalibration{Key: key, Index: i}
+		if c.idleCount[i] > 0 {
+			kc.Rest = float32(c.idleSum[i]) / float32(c.idleCount[i]) / 10
+			kc.Noise = float32(c.idleMax[i]-c.idleMin[i]) / 10
+		}
+		kc.MaxTravel = float32(c.max[i]) / 10
+		kc.Pressed = c.max[i] > c.idleMax[i]
+
+		calibration.Keys = append(calibration.Keys, kc)
+	}
+
+	return calibration
+}
+
+func (c *Calibration) Issues() []CalibrationIssue {
+	issues := make([]CalibrationIssue, 0)
+	for _, key := range c.Keys {
+		switch {
+		case !key.Pressed:
+			issues = append(issues, CalibrationIssue{key.Key, "never pressed"})
+		case key.MaxTravel < CalibrationMinMaxTravel:
+			issues = append(issues, CalibrationIssue{key.Key, fmt.Sprintf("max travel %.1fmm", key.MaxTravel)})
+		}
+
+		if key.Noise > CalibrationMaxNoise {
+			issues = append(issues, CalibrationIssue{key.Key, fmt.Sprintf("noise %.1fmm at rest", key.Noise)})
+		}
+
+		if key.Rest > CalibrationMaxOffset {
+			issues = append(issues, CalibrationIssue{key.Key, fmt.Sprintf("rests at %.1fmm", key.Rest)})
+		}
+	}
+
+	return issues
+}
+
+// NoiseBand is how deep a key reads without being touched, actuations at or below it can fire on their own
+func (c *Calibration) NoiseBand(index int) float32 {
+	for _, key := range c.Keys {
+		if key.Index == index {
+			return key.Rest + key.Noise
+		}
+	}
+	return 0
+}
+
+// Keys whose actuation point in the tables sits inside their noise band
+func (c *Calibration) NoisyActuations(actuations []byte) []string {
+	noisy := make([]string, 0)
+	for _, key := range c.Keys {
+		if key.Index >= len(actuations) {
+			continue
+		}
+
+		if float32(actuations[key.Index])/10 <= key.Rest+key.Noise {
+			noisy = append(noisy, key.Key)
+		}
+	}
+	return noisy
+}
+
+// Calibrations live next to profiles, one file per device serial
+func CalibrationPath(dir, serial string) string {
+	return filepath.Join(dir, "calibration", SerialFileName(serial)+".json")
+}
+
+func LoadCalibration(path string) (*Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var calibration Calibration
+	if err := json.Unmarshal(data, &calibration); err != nil {
+		return nil, fmt.Errorf("failed to parse calibration: %w", err)
+	}
+
+	return &calibration, nil
+}
+
+func (c *Calibration) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Serials are vendor-controlled strings, keep them filesystem-friendly
+func SerialFileName(serial string) string {
+	if serial == "" {
+		serial = "unknown"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, serial)
+}
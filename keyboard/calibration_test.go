@@ -0,0 +1,88 @@
+package keyboard
+
+import (
+	"math"
+	"testing"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// A snapshot like TrackKeys sends for an update of row, rows that haven't reported yet are zeros
+func rowUpdate(row int, depths map[int]byte) Travel {
+	t := Travel{Depths: make([]byte, len(driver.KEYBOARD_LAYOUT)), Row: row}
+	for i, depth := range depths {
+		t.Depths[i] = depth
+	}
+	return t
+}
+
+func TestCalibrator(t *testing.T) {
+	w := keyIndex(t, "W")
+	row := driver.GetRowByIndex(w)
+	otherRow := (row + 1) % 3
+
+	tests := []struct {
+		name  string
+		idle  []Travel
+		press []Travel
+		want  KeyCalibration
+	}{
+		{
+			name: "partial first frame",
+			idle: []Travel{
+				rowUpdate(otherRow, nil),
+				rowUpdate(row, map[int]byte{w: 1}),
+				rowUpdate(row, map[int]byte{w: 2}),
+				rowUpdate(row, map[int]byte{w: 3}),
+			},
+			press: []Travel{rowUpdate(row, map[int]byte{w: 38})},
+			want:  KeyCalibration{Rest: 0.2, Noise: 0.2, MaxTravel: 3.8, Pressed: true},
+		},
+		{
+			name: "other rows keep their last reading",
+			idle: []Travel{
+				rowUpdate(row, map[int]byte{w: 2}),
+				rowUpdate(otherRow, map[int]byte{w: 2}),
+			},
+			press: []Travel{rowUpdate(row, map[int]byte{w: 20}), rowUpdate(row, map[int]byte{w: 40})},
+			want:  KeyCalibration{Rest: 0.2, MaxTravel: 4.0, Pressed: true},
+		},
+		{
+			name: "never pressed",
+			idle: []Travel{rowUpdate(row, map[int]byte{w: 1})},
+			want: KeyCalibration{Rest: 0.1},
+		},
+		{
+			name: "never idle",
+			want: KeyCalibration{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calibrator := NewCalibrator()
+			for _, travel := range test.idle {
+				calibrator.Idle(travel)
+			}
+			for _, travel := range test.press {
+				calibrator.Press(travel)
+			}
+
+			var got KeyCalibration
+			for _, key := range calibrator.Result("serial", "A75").Keys {
+				if key.Index == w {
+					got = key
+				}
+			}
+
+			want := test.want
+			if !near(got.Rest, want.Rest) || !near(got.Noise, want.Noise) || !near(got.MaxTravel, want.MaxTravel) || got.Pressed != want.Pressed {
+				t.Errorf("got %+v, expected %+v", got, want)
+			}
+		})
+	}
+}
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 0.001
+}
@@ -0,0 +1,140 @@
+package keyboard
+
+import (
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+const (
+	// Two actuations of the same key closer than this are a double-fire, nobody types that fast
+	ChatterWindow = 30 * time.Millisecond
+
+	// Added on top of the observed noise for the suggested minimums, in 0.1mm units
+	safetyMargin = 1
+)
+
+type KeyDiagnosis struct {
+	Key   string
+	Index int
+
+	IdleCrossings int     // Actuations while nobody touched the keyboard
+	Chatter       int     // Actuations within ChatterWindow of the previous one
+	Noise         float32 // mm, spread of the idle readings
+
+	Actuation       float32 // Configured, mm
+	RapidTrigger    float32 // Smallest configured RT distance, mm
+	MinActuation    float32 // Suggested, mm
+	MinRapidTrigger float32 // Suggested, mm
+}
+
+func (k *KeyDiagnosis) Flagged() bool {
+	return k.IdleCrossings > 0 || k.Chatter > 0 ||
+		k.Actuation < k.MinActuation ||
+		(k.RapidTrigger > 0 && k.RapidTrigger < k.MinRapidTrigger)
+}
+
+type keyStats struct {
+	lastPress time.Time
+	idleMin   byte
+	idleMax   byte
+	idleCross int
+	chatter   int
+}
+
+// Replays the tracking stream through the actuation/rapid trigger logic to find keys that fire without
+// being pressed, first while the keyboard is idle, then while typing
+type ChatterDetector struct {
+	actuator *Actuator
+	keys     []keyStats
+}
+
+// Tables in 0.1mm units like the ones sent to the keyboard
+func NewChatterDetector(actuations, downstrokes, upstrokes []byte, rapidTrigger bool) *ChatterDetector {
+	keys := make([]keyStats, len(driver.KEYBOARD_LAYOUT))
+	for i := range keys {
+		keys[i].idleMin = 0xff
+	}
+
+	return &ChatterDetector{
+		actuator: NewActuator(actuations, downstrokes, upstrokes, rapidTrigger),
+		keys:     keys,
+	}
+}
+
+// Like calibration only the updated row counts, a zero from a row that hasn't reported would look like noise
+func (c *ChatterDetector) Idle(t Travel) {
+	start, end := t.RowKeys()
+	for i := start; i < end; i++ {
+		depth := t.Depths[i]
+		key := &c.keys[i]
+		key.idleMin = min(key.idleMin, depth)
+		key.idleMax = max(key.idleMax, depth)
+
+		if c.actuator.Step(i, depth) {
+			key.idleCross++
+			key.lastPress = t.At
+		}
+	}
+}
+
+func (c *ChatterDetector) Typing(t Travel) {
+	for i, depth := range t.Depths {
+		key := &c.keys[i]
+		if !c.actuator.Step(i, depth) {
+			continue
+		}
+
+		if !key.lastPress.IsZero() && t.At.Sub(key.lastPress) < ChatterWindow {
+			key.chatter++
+		}
+		key.lastPress = t.At
+	}
+}
+
+func (c *ChatterDetector) Result() []KeyDiagnosis {
+	result := make([]KeyDiagnosis, 0)
+	for i, name := range driver.KEYBOARD_LAYOUT {
+		if name == "" {
+			continue
+		}
+
+		key := &c.keys[i]
+		noise := byte(0)
+		if key.idleMax >= key.idleMin {
+			noise = key.idleMax - key.idleMin
+		}
+
+		diagnosis := KeyDiagnosis{
+			Key:             name,
+			Index:           i,
+			IdleCrossings:   key.idleCross,
+			Chatter:         key.chatter,
+			Noise:           float32(noise) / 10,
+			Actuation:       float32(tableAt(c.actuator.actuations, i, driver.DEFAULT_ACTUATION)) / 10,
+			MinActuation:    float32(int(key.idleMax)+safetyMargin) / 10,
+			MinRapidTrigger: float32(int(noise)+safetyMargin) / 10,
+		}
+
+		if c.actuator.rapidTrigger {
+			rt := min(tableAt(c.actuator.downstrokes, i, 0), tableAt(c.actuator.upstrokes, i, 0))
+			diagnosis.RapidTrigger = float32(rt) / 10
+		}
+
+		// A key that chattered while typing needs more headroom than the idle noise suggests
+		if key.chatter > 0 {
+			diagnosis.MinRapidTrigger += float32(safetyMargin) / 10
+		}
+
+		result = append(result, diagnosis)
+	}
+
+	return result
+}
+
+func tableAt(table []byte, i int, fallback byte) byte {
+	if i < len(table) {
+		return table[i]
+	}
+	return fallback
+}
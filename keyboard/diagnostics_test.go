@@ -0,0 +1,108 @@
+package keyboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+func travelWith(index int, depth byte, at time.Time) Travel {
+	depths := make([]byte, len(driver.KEYBOARD_LAYOUT))
+	depths[index] = depth
+	return Travel{Depths: depths, Row: driver.GetRowByIndex(index), At: at}
+}
+
+func diagnosisFor(t *testing.T, result []KeyDiagnosis, index int) KeyDiagnosis {
+	for _, key := range result {
+		if key.Index == index {
+			return key
+		}
+	}
+	t.Fatalf("no diagnosis for key %d", index)
+	return KeyDiagnosis{}
+}
+
+func TestChatterDetectorIdleCrossing(t *testing.T) {
+	w := keyIndex(t, "W")
+	actuations := make([]byte, len(driver.KEYBOARD_LAYOUT))
+	for i := range actuations {
+		actuations[i] = 0x02 // 0.2mm
+	}
+
+	detector := NewChatterDetector(actuations, nil, nil, false)
+	start := time.Now()
+	for i, depth := range []byte{0, 1, 3, 0, 2, 0} {
+		detector.Idle(travelWith(w, depth, start.Add(time.Duration(i)*10*time.Millisecond)))
+	}
+
+	key := diagnosisFor(t, detector.Result(), w)
+	if key.IdleCrossings != 2 {
+		t.Fatalf("expected 2 idle crossings, got %d", key.IdleCrossings)
+	}
+	if !key.Flagged() || key.MinActuation < 0.39 {
+		t.Fatalf("expected W to be flagged with a minimum of 0.4mm, got %+v", key)
+	}
+
+	other := diagnosisFor(t, detector.Result(), keyIndex(t, "A"))
+	if other.Flagged() {
+		t.Fatalf("A never moved but was flagged: %+v", other)
+	}
+}
+
+func TestChatterDetectorPartialFirstFrame(t *testing.T) {
+	w := keyIndex(t, "W")
+	row := driver.GetRowByIndex(w)
+	actuations := make([]byte, len(driver.KEYBOARD_LAYOUT))
+	for i := range actuations {
+		actuations[i] = 0x10
+	}
+
+	detector := NewChatterDetector(actuations, nil, nil, false)
+	// Another row reports first, W is still a zero in that snapshot
+	detector.Idle(rowUpdate((row+1)%3, nil))
+	for _, depth := range []byte{3, 4, 3} {
+		detector.Idle(rowUpdate(row, map[int]byte{w: depth}))
+	}
+
+	key := diagnosisFor(t, detector.Result(), w)
+	if !near(key.Noise, 0.1) {
+		t.Fatalf("expected 0.1mm of noise, got %.1fmm", key.Noise)
+	}
+}
+
+func TestChatterDetectorRapidTriggerChatter(t *testing.T) {
+	w := keyIndex(t, "W")
+	fill := func(v byte) []byte {
+		table := make([]byte, len(driver.KEYBOARD_LAYOUT))
+		for i := range table {
+			table[i] = v
+		}
+		return table
+	}
+
+	detector := NewChatterDetector(fill(0x05), fill(0x01), fill(0x01), true)
+	start := time.Now()
+	// Held down past the actuation point but wobbling by 0.1mm, RT fires on every wobble
+	for i, depth := range []byte{0, 20, 19, 20, 19, 20} {
+		detector.Typing(travelWith(w, depth, start.Add(time.Duration(i)*5*time.Millisecond)))
+	}
+
+	key := diagnosisFor(t, detector.Result(), w)
+	if key.Chatter != 2 {
+		t.Fatalf("expected 2 double-fires, got %d", key.Chatter)
+	}
+	if !key.Flagged() {
+		t.Fatalf("expected W to be flagged: %+v", key)
+	}
+}
+
+func keyIndex(t *testing.T, name string) int {
+	t.Helper()
+
+	i, err := driver.ResolveKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
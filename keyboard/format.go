@@ -0,0 +1,329 @@
+package keyboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile file formats. Everything is turned into JSON before it's parsed, so the json tags on Profile are the
+// only field names there are.
+type Format string
+
+const (
+	FormatJSON  Format = "json"
+	FormatJSONC Format = "jsonc" // JSON with // and /* */ comments and trailing commas
+	FormatYAML  Format = "yaml"
+	FormatTOML  Format = "toml"
+)
+
+// Extensions profiles can have, in the order they're looked for when a profile is named without one
+var ProfileExtensions = []string{".json", ".jsonc", ".yaml", ".yml", ".toml"}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "json":
+		return FormatJSON, nil
+	case "jsonc":
+		return FormatJSONC, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("unknown profile format %q, use json, jsonc, yaml or toml", name)
+}
+
+// Extension for files written in the format
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// DetectFormat goes by the file extension, or by the content when there's no extension it knows
+func DetectFormat(source string, data []byte) Format {
+	if format, err := ParseFormat(extension(source)); err == nil {
+		return format
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("//")) || bytes.HasPrefix(trimmed, []byte("/*")):
+		return FormatJSONC
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		if json.Valid(trimmed) {
+			return FormatJSON
+		}
+		return FormatJSONC
+	}
+
+	// YAML would read most TOML as a plain string, so TOML gets the first try
+	var probe map[string]any
+	if err := toml.Unmarshal(data, &probe); err == nil && len(probe) > 0 {
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+func HasProfileExtension(name string) bool {
+	_, err := ParseFormat(extension(name))
+	return err == nil && extension(name) != ""
+}
+
+// TrimProfileExtension turns a file name back into a profile name
+func TrimProfileExtension(name string) string {
+	if HasProfileExtension(name) {
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// FindProfile adds the extension of whichever profile file exists for a path given without one, .json if none do
+func FindProfile(path string) string {
+	if HasProfileExtension(path) {
+		return path
+	}
+
+	for _, ext := range ProfileExtensions {
+		if _, err := os.Stat(path + ext); err == nil {
+			return path + ext
+		}
+	}
+	return path + FormatJSON.Extension()
+}
+
+func extension(source string) string {
+	if IsURL(source) {
+		if u, err := url.Parse(source); err == nil {
+			source = u.Path
+		}
+	}
+	return filepath.Ext(source)
+}
+
+// ParseProfileFormat parses a profile in any of the formats, upgrading it if it's from an older schema version
+func ParseProfileFormat(data []byte, format Format) (*Profile, error) {
+	profile, _, err := decodeProfile(data, format)
+	return profile, err
+}
+
+// ToJSON converts a document in the format to JSON. JSONC keeps its line and column positions.
+func ToJSON(data []byte, format Format) ([]byte, error) {
+	var doc any
+
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatJSONC:
+		return stripJSONC(data), nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse profile YAML: %w", err)
+		}
+	case FormatTOML:
+		var table map[string]any
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("failed to parse profile TOML: %w", err)
+		}
+		doc = table
+	default:
+		return nil, fmt.Errorf("unknown profile format %q", format)
+	}
+
+	return json.Marshal(stringKeys(doc))
+}
+
+// MarshalProfile writes the profile in the format, as the current schema version. Fields come out sorted for
+// YAML and TOML.
+func MarshalProfile(p *Profile, format Format) ([]byte, error) {
+	stamped := *p
+	stamped.SchemaVersion = SchemaVersion
+
+	data, err := json.MarshalIndent(&stamped, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatJSON || format == FormatJSONC {
+		return append(data, '\n'), nil
+	}
+
+	// Only unset maps are null here, TOML can just leave them out
+	return marshalDocument(data, format, true)
+}
+
+// ConvertProfile translates a profile file between formats as written, so fields that aren't set stay unset and
+// "extends" is kept. Comments are lost.
+func ConvertProfile(data []byte, from, to Format) ([]byte, error) {
+	converted, err := ToJSON(data, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == FormatJSON || to == FormatJSONC {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, converted, "", "    "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	return marshalDocument(converted, to, false)
+}
+
+func marshalDocument(data []byte, format Format, dropNull bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	normalizeNumbers(doc)
+
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(doc)
+	case FormatTOML:
+		// A null removes a field from the profile it extends, leaving it out would silently change that
+		if path := findNull(doc, "$", dropNull); path != "" {
+			return nil, fmt.Errorf("%s is null, which TOML can't express", path)
+		}
+
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown profile format %q", format)
+}
+
+// Comments and trailing commas become spaces, newlines stay so errors still point at the right line
+func stripJSONC(data []byte) []byte {
+	out := bytes.Clone(data)
+
+	// Comments first, so a comment after a trailing comma doesn't hide it
+	walkJSON(out, func(i int) int {
+		switch {
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			stop := len(out)
+			if end := bytes.Index(out[i+2:], []byte("*/")); end >= 0 {
+				stop = i + 2 + end + 2
+			}
+			for ; i < stop; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+		return i
+	})
+
+	walkJSON(out, func(i int) int {
+		if out[i] == ',' {
+			next := i + 1
+			for next < len(out) && strings.ContainsRune(" \t\r\n", rune(out[next])) {
+				next++
+			}
+			if next < len(out) && (out[next] == '}' || out[next] == ']') {
+				out[i] = ' '
+			}
+		}
+		return i
+	})
+
+	return out
+}
+
+// Calls fn for every byte outside of strings, fn returns the index it got up to
+func walkJSON(data []byte, fn func(i int) int) {
+	inString := false
+	for i := 0; i < len(data); i++ {
+		switch {
+		case inString && data[i] == '\\':
+			i++
+		case inString:
+			inString = data[i] != '"'
+		case data[i] == '"':
+			inString = true
+		default:
+			if next := fn(i); next > i {
+				i = next - 1
+			}
+		}
+	}
+}
+
+// YAML gives map[any]any for keys like 1: that aren't strings, JSON needs string keys
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]any:
+		for key, value := range v {
+			v[key] = stringKeys(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = stringKeys(value)
+		}
+		return v
+	}
+	return v
+}
+
+// Whole numbers stay whole, 2 rather than 2.0, and the rest come out as short as JSON had them
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = normalizeNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = normalizeNumbers(value)
+		}
+	}
+	return v
+}
+
+// Path of the first null, or with drop set removes them all and returns ""
+func findNull(m map[string]any, path string, drop bool) string {
+	for _, key := range sortedKeys(m) {
+		switch value := m[key].(type) {
+		case nil:
+			if !drop {
+				return path + jsonKey(key)
+			}
+			delete(m, key)
+		case map[string]any:
+			if found := findNull(value, path+jsonKey(key), drop); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
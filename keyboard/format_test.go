@@ -0,0 +1,67 @@
+package keyboard
+
+import "testing"
+
+func TestParseProfileFormats(t *testing.T) {
+	sources := map[Format]string{
+		FormatJSONC: `// base
+{
+    "model": "A75", /* "model": "G65" */
+    "defaultActuation": 2,
+    "actuationPoints": {"W": 0.2, "//": 0.3,}, // trailing comma
+}`,
+		FormatYAML: `
+model: A75
+defaultActuation: 2
+actuationPoints:
+  W: 0.2
+  "//": 0.3
+`,
+		FormatTOML: `
+model = "A75"
+defaultActuation = 2
+
+[actuationPoints]
+W = 0.2
+"//" = 0.3
+`,
+	}
+
+	for format, source := range sources {
+		if detected := DetectFormat("", []byte(source)); detected != format {
+			t.Errorf("%s: detected as %s", format, detected)
+		}
+
+		profile, err := ParseProfileFormat([]byte(source), format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if profile.Model != "A75" || profile.DefaultActuation != 2 || profile.ActuationPoints["W"] != 0.2 || profile.ActuationPoints["//"] != 0.3 {
+			t.Errorf("%s: parsed as %+v", format, profile)
+		}
+
+		for _, to := range []Format{FormatJSON, FormatYAML, FormatTOML} {
+			converted, err := ConvertProfile([]byte(source), format, to)
+			if err != nil {
+				t.Errorf("%s to %s: %v", format, to, err)
+				continue
+			}
+
+			back, err := ParseProfileFormat(converted, to)
+			if err != nil || back.Model != profile.Model || back.ActuationPoints["//"] != 0.3 {
+				t.Errorf("%s to %s: got %s (%v)", format, to, converted, err)
+			}
+		}
+	}
+}
+
+func TestParseProfileYAMLNumericKeys(t *testing.T) {
+	profile, err := ParseProfileFormat([]byte("actuationPoints:\n  1: 0.5\n"), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ActuationPoints["1"] != 0.5 {
+		t.Errorf("expected key 1, got %v", profile.ActuationPoints)
+	}
+}
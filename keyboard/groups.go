@@ -0,0 +1,163 @@
+package keyboard
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// Selectors start with this in actuationPoints, rapidTriggers and groups
+const SelectorPrefix = "@"
+
+// Built-in groups, user groups in the profile can't reuse these names
+var builtinGroups = map[string]func() []int{
+	"wasd":     func() []int { return driver.WASD_KEYS },
+	"numerals": func() []int { return driver.NUMERALS_KEYS },
+	"letters":  func() []int { return driver.CHARACTER_KEYS },
+	"fnrow":    func() []int { return keysMatching(isFunctionKey) },
+	"numpad":   func() []int { return keysMatching(func(k string) bool { return strings.HasPrefix(k, "KP") }) },
+	"arrows":   func() []int { return keysMatching(func(k string) bool { return strings.HasPrefix(k, "ARR_") }) },
+	"all":      func() []int { return keysMatching(func(k string) bool { return true }) },
+}
+
+func BuiltinGroups() []string {
+	names := make([]string, 0, len(builtinGroups)+1)
+	for name := range builtinGroups {
+		names = append(names, SelectorPrefix+name)
+	}
+	names = append(names, SelectorPrefix+"row:1-6")
+
+	sort.Strings(names)
+	return names
+}
+
+// UnknownGroupError is returned for an @group that's neither built in nor defined in the profile
+type UnknownGroupError struct {
+	Name string // As written, with the @
+}
+
+func (e *UnknownGroupError) Error() string {
+	return fmt.Sprintf("unknown group %s", e.Name)
+}
+
+func IsSelector(name string) bool {
+	return strings.HasPrefix(name, SelectorPrefix)
+}
+
+// ResolveSelector expands @group, @row:N or a plain key name to layout indexes. User groups may reference keys
+// and other groups.
+func ResolveSelector(name string, groups map[string][]string) ([]int, error) {
+	return resolveSelector(name, groups, make(map[string]bool))
+}
+
+func resolveSelector(name string, groups map[string][]string, visiting map[string]bool) ([]int, error) {
+	if !IsSelector(name) {
+		i, err := driver.ResolveKey(name)
+		if err != nil {
+			return nil, err
+		}
+		return []int{i}, nil
+	}
+
+	group := strings.ToLower(strings.TrimPrefix(name, SelectorPrefix))
+	if row, ok := strings.CutPrefix(group, "row:"); ok {
+		n, err := strconv.Atoi(row)
+		if err != nil || n < 1 || n > driver.LAYOUT_ROWS {
+			return nil, fmt.Errorf("%s: rows go from 1 to %d", name, driver.LAYOUT_ROWS)
+		}
+		return keysMatchingIndex(func(i int) bool { return i/driver.LAYOUT_COLUMNS == n-1 }), nil
+	}
+
+	if builtin, ok := builtinGroups[group]; ok {
+		return builtin(), nil
+	}
+
+	members, ok := lookupGroup(groups, group)
+	if !ok {
+		return nil, &UnknownGroupError{Name: name}
+	}
+
+	if visiting[group] {
+		return nil, fmt.Errorf("group %s includes itself", name)
+	}
+	visiting[group] = true
+	defer delete(visiting, group)
+
+	indexes := make([]int, 0, len(members))
+	for _, member := range members {
+		resolved, err := resolveSelector(member, groups, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("in %s: %w", name, err)
+		}
+		indexes = append(indexes, resolved...)
+	}
+
+	return indexes, nil
+}
+
+// Group names are case-insensitive like key names
+func lookupGroup(groups map[string][]string, name string) ([]string, bool) {
+	for groupName, members := range groups {
+		if strings.EqualFold(groupName, name) {
+			return members, true
+		}
+	}
+	return nil, false
+}
+
+// ExpandSelectors turns a section of the profile into per-index values. Groups go first, bigger ones before
+// smaller ones so the more specific group wins, then single keys override whatever a group set.
+func ExpandSelectors[V any](section map[string]V, groups map[string][]string) (map[int]V, error) {
+	type selection struct {
+		name    string
+		indexes []int
+	}
+
+	selections := make([]selection, 0, len(section))
+	for _, name := range sortedKeys(section) {
+		indexes, err := ResolveSelector(name, groups)
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection{name, indexes})
+	}
+
+	sort.SliceStable(selections, func(i, j int) bool {
+		a, b := selections[i], selections[j]
+		if IsSelector(a.name) != IsSelector(b.name) {
+			return IsSelector(a.name)
+		}
+		return len(a.indexes) > len(b.indexes)
+	})
+
+	values := make(map[int]V)
+	for _, s := range selections {
+		for _, i := range s.indexes {
+			values[i] = section[s.name]
+		}
+	}
+
+	return values, nil
+}
+
+func isFunctionKey(key string) bool {
+	n, err := strconv.Atoi(strings.TrimPrefix(key, "F"))
+	return strings.HasPrefix(key, "F") && err == nil && n >= 1 && n <= 12
+}
+
+func keysMatching(match func(key string) bool) []int {
+	return keysMatchingIndex(func(i int) bool { return match(driver.KEYBOARD_LAYOUT[i]) })
+}
+
+func keysMatchingIndex(match func(i int) bool) []int {
+	indexes := make([]int, 0)
+	for i, key := range driver.KEYBOARD_LAYOUT {
+		if key != "" && match(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,47 @@
+package keyboard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+func TestExpandSelectors(t *testing.T) {
+	groups := map[string][]string{
+		"movement": {"@wasd", "space"},
+		"loop":     {"@Loop"},
+	}
+
+	points, err := ExpandSelectors(map[string]float32{
+		"@all":      3.0,
+		"@row:2":    2.5,
+		"@MOVEMENT": 0.4,
+		"SPACE":     1.0,
+	}, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]float32{"W": 0.4, "SPACE": 1.0, "ESC": 3.0, "TILDE": 2.5, "1": 2.5, "TAB": 3.0}
+	for key, want := range expect {
+		if got := points[keyIndex(t, key)]; got != want {
+			t.Errorf("%s: expected %.1f, got %.1f", key, want, got)
+		}
+	}
+
+	if fn, err := ResolveSelector("@fnrow", nil); err != nil || len(fn) != 12 || driver.KEYBOARD_LAYOUT[fn[0]] != "F1" {
+		t.Errorf("@fnrow: got %v, %v", fn, err)
+	}
+
+	for _, bad := range []string{"@loop", "@row:7", "@nope"} {
+		if _, err := ResolveSelector(bad, groups); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+
+	var groupErr *UnknownGroupError
+	if _, err := ResolveSelector("@nested", map[string][]string{"nested": {"@nope"}}); !errors.As(err, &groupErr) || groupErr.Name != "@nope" {
+		t.Errorf("@nested: expected an unknown group error for @nope, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// Sections keyed by key name, merged per key so "w" in a child replaces "W" from the parent
+var keyMapSections = map[string]bool{"actuationPoints": true, "rapidTriggers": true}
+
+// ResolveProfile parses a profile read from source and merges in whatever it extends. Parents are looked up
+// relative to source, so "extends": "base" next to a file means base.json (or .yaml...) in the same directory.
+func ResolveProfile(data []byte, format Format, source string) (*Profile, error) {
+	profile, _, err := resolveProfile(data, format, source, nil)
+	return profile, err
+}
+
+// LoadProfiles loads every source like LoadProfile and merges them left to right, so later profiles override
+// earlier ones per field and per key. Handy for small fragments like lighting only on top of a base profile.
+func LoadProfiles(sources ...string) (*Profile, error) {
+	if len(sources) == 1 {
+		return LoadProfile(sources[0])
+	}
+
+	merged := make(map[string]any)
+	for _, source := range sources {
+		data, err := ReadProfile(source)
+		if err != nil {
+			return nil, err
+		}
+
+		_, raw, err := resolveProfile(data, DetectFormat(source, data), source, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		merged = mergeRaw(merged, raw, "")
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProfile(data)
+}
+
+// Returns the profile along with its merged raw fields, which the child (if any) is merged onto
+func resolveProfile(data []byte, format Format, source string, chain []string) (*Profile, map[string]any, error) {
+	profile, raw, err := decodeProfile(data, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(raw, "extends")
+
+	if profile.Extends == "" {
+		return profile, raw, nil
+	}
+
+	chain = append(chain, sourceID(source))
+	parent := extendsSource(source, profile.Extends)
+	for _, seen := range chain {
+		if seen == sourceID(parent) {
+			return nil, nil, fmt.Errorf("profile inheritance cycle: %s -> %s", strings.Join(chain, " -> "), sourceID(parent))
+		}
+	}
+
+	parentData, err := ReadProfile(parent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("extends %q: %w", profile.Extends, err)
+	}
+
+	_, parentRaw, err := resolveProfile(parentData, DetectFormat(parent, parentData), parent, chain)
+	if err != nil {
+		return nil, nil, fmt.Errorf("extends %q: %w", profile.Extends, err)
+	}
+
+	merged := mergeRaw(parentRaw, raw, "")
+	data, err = json.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved, err := ParseProfile(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("extends %q: %w", profile.Extends, err)
+	}
+	return resolved, merged, nil
+}
+
+// SparseProfile goes the other way from ResolveProfile: it takes a complete profile that extends another, e.g.
+// one edited after resolving it, and returns it as JSON with only what differs from the parent. That way later
+// changes to the parent still come through. Keys and groups the parent has and the profile dropped are written
+// as null so they stay removed.
+func SparseProfile(profile *Profile, source string) ([]byte, error) {
+	if profile.Extends == "" {
+		return nil, fmt.Errorf("profile doesn't extend another")
+	}
+
+	parentSource := extendsSource(source, profile.Extends)
+	parentData, err := ReadProfile(parentSource)
+	if err != nil {
+		return nil, fmt.Errorf("extends %q: %w", profile.Extends, err)
+	}
+
+	parent, _, err := resolveProfile(parentData, DetectFormat(parentSource, parentData), parentSource, []string{sourceID(source)})
+	if err != nil {
+		return nil, fmt.Errorf("extends %q: %w", profile.Extends, err)
+	}
+
+	childRaw, err := profileRaw(profile)
+	if err != nil {
+		return nil, err
+	}
+	parentRaw, err := profileRaw(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	sparse := diffRaw(childRaw, parentRaw, "")
+	sparse["schemaVersion"] = SchemaVersion
+	sparse["extends"] = profile.Extends
+	return json.Marshal(sparse)
+}
+
+// Every field as it would be written, so both sides of a diff went through the same float32s
+func profileRaw(p *Profile) (map[string]any, error) {
+	plain := *p
+	plain.Extends, plain.SchemaVersion = "", 0
+
+	data, err := json.Marshal(&plain)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	return raw, json.Unmarshal(data, &raw)
+}
+
+// What mergeRaw would need on top of parent to get child
+func diffRaw(child, parent map[string]any, section string) map[string]any {
+	diff := make(map[string]any)
+	for name, value := range child {
+		parentName, inParent := findRawKey(parent, name, section)
+		if inParent && reflect.DeepEqual(value, parent[parentName]) {
+			continue
+		}
+
+		if section == "" {
+			// A null map is an empty one here, e.g. every key override dropped
+			childObject, childIsObject := value.(map[string]any)
+			parentObject, parentIsObject := parent[parentName].(map[string]any)
+			if (childIsObject || value == nil) && (parentIsObject || !inParent || parent[parentName] == nil) {
+				if sub := diffRaw(childObject, parentObject, name); len(sub) > 0 {
+					diff[name] = sub
+				}
+				continue
+			}
+		}
+
+		diff[name] = value
+	}
+
+	// Only within objects, top-level fields the child doesn't have are simply inherited
+	if section != "" {
+		for name := range parent {
+			if _, ok := findRawKey(child, name, section); !ok {
+				diff[name] = nil
+			}
+		}
+	}
+
+	return diff
+}
+
+// Key maps match any name of the same key, like mergeRaw
+func findRawKey(m map[string]any, name, section string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+
+	if keyMapSections[section] {
+		for existing := range m {
+			if sameKey(existing, name) {
+				return existing, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ReadProfile reads a profile file or downloads it, without parsing it
+func ReadProfile(source string) ([]byte, error) {
+	if IsURL(source) {
+		return Download(source)
+	}
+	return os.ReadFile(source)
+}
+
+// Where the parent lives: URLs as-is, names relative to the child's directory (or URL). Files named without an
+// extension can be in any format, on a server they're taken to be .json.
+func extendsSource(child, extends string) string {
+	if IsURL(extends) {
+		return extends
+	}
+
+	if IsURL(child) {
+		if !HasProfileExtension(extends) {
+			extends += FormatJSON.Extension()
+		}
+
+		base, err := url.Parse(child)
+		ref, refErr := url.Parse(extends)
+		if err == nil && refErr == nil {
+			return base.ResolveReference(ref).String()
+		}
+		return extends
+	}
+
+	if filepath.IsAbs(extends) {
+		return FindProfile(extends)
+	}
+	return FindProfile(filepath.Join(filepath.Dir(child), extends))
+}
+
+// Absolute paths so the same file reached two ways is still caught as a cycle
+func sourceID(source string) string {
+	if IsURL(source) {
+		return source
+	}
+
+	if abs, err := filepath.Abs(source); err == nil {
+		return abs
+	}
+	return source
+}
+
+// Objects merge field by field, anything else in the child replaces the parent. A null in the child removes
+// the field, e.g. to drop a key override from the base profile.
+func mergeRaw(parent, child map[string]any, section string) map[string]any {
+	merged := make(map[string]any, len(parent)+len(child))
+	for name, value := range parent {
+		merged[name] = value
+	}
+
+	for name, value := range child {
+		if keyMapSections[section] {
+			for existing := range merged {
+				if sameKey(existing, name) {
+					delete(merged, existing)
+				}
+			}
+		}
+
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+
+		childObject, childIsObject := value.(map[string]any)
+		parentObject, parentIsObject := merged[name].(map[string]any)
+		if childIsObject && parentIsObject && section == "" {
+			merged[name] = mergeRaw(parentObject, childObject, name)
+			continue
+		}
+
+		merged[name] = value
+	}
+
+	return merged
+}
+
+// Same key under any of its names, selectors only by name
+func sameKey(a, b string) bool {
+	return canonicalKey(a) == canonicalKey(b)
+}
+
+func canonicalKey(name string) string {
+	if IsSelector(name) {
+		return strings.ToLower(name)
+	}
+
+	if i, err := driver.ResolveKey(name); err == nil {
+		return strconv.Itoa(i)
+	}
+	return name
+}
@@ -0,0 +1,100 @@
+package keyboard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProfiles(t *testing.T, profiles map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range profiles {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadProfileExtends(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"base": `{"model": "A75", "turbo": true, "defaultActuation": 2.0, "light": {"enabled": true, "speed": 5},
+			"actuationPoints": {"W": 0.5, "TAB": 3.0}}`,
+		"game": `{"extends": "base", "light": {"speed": 9}, "actuationPoints": {"w": 0.3, "TAB": null}}`,
+		"more": `{"extends": "game", "turbo": false}`,
+	})
+
+	profile, err := LoadProfile(filepath.Join(dir, "more.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile.Extends != "" || profile.Model != "A75" || profile.Turbo || profile.DefaultActuation != 2.0 {
+		t.Errorf("fields not inherited: %+v", profile)
+	}
+	if !profile.Light.Enabled || profile.Light.Speed != 9 {
+		t.Errorf("light not merged: %+v", profile.Light)
+	}
+	if len(profile.ActuationPoints) != 1 || profile.ActuationPoints["w"] != 0.3 {
+		t.Errorf("actuation points not merged per key: %v", profile.ActuationPoints)
+	}
+}
+
+func TestLoadProfileExtendsCycle(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"a": `{"extends": "b"}`,
+		"b": `{"extends": "a.json"}`,
+	})
+
+	_, err := LoadProfile(filepath.Join(dir, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestLoadProfilesLayers(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"base":       `{"model": "A75", "defaultActuation": 2.0, "light": {"enabled": true, "brightness": 9}}`,
+		"wasd-low":   `{"actuationPoints": {"@wasd": 0.3}}`,
+		"lights-off": `{"light": {"enabled": false}}`,
+	})
+
+	profile, err := LoadProfiles(filepath.Join(dir, "base.json"), filepath.Join(dir, "wasd-low.json"), filepath.Join(dir, "lights-off.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if profile.Model != "A75" || profile.ActuationPoints["@wasd"] != 0.3 {
+		t.Errorf("layers not merged: %+v", profile)
+	}
+	if profile.Light.Enabled || profile.Light.Brightness != 9 {
+		t.Errorf("later layer should only override what it sets: %+v", profile.Light)
+	}
+}
+
+func TestSparseProfile(t *testing.T) {
+	dir := writeProfiles(t, map[string]string{
+		"base": `{"model": "A75", "defaultActuation": 2.0, "actuationPoints": {"W": 0.5, "TAB": 3.0},
+			"groups": {"move": ["W", "A"]}}`,
+	})
+
+	profile, err := ParseProfile([]byte(`{"extends": "base", "model": "A75", "defaultActuation": 1.5,
+		"actuationPoints": {"w": 0.5, "A": 1.0}, "groups": {"move": ["W", "A"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := SparseProfile(profile, filepath.Join(dir, "game.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same model, same W under another name and the same group aren't repeated
+	want := `{"actuationPoints":{"A":1,"TAB":null},"defaultActuation":1.5,"extends":"base","schemaVersion":1}`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
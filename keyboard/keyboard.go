@@ -0,0 +1,364 @@
+// Package keyboard is the high-level API on top of driver: it loads profiles and applies them to a
+// connected DrunkDeer keyboard the same way the drunkdeer CLI does.
+package keyboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/sstallion/go-hid"
+)
+
+const (
+	// Flaky hubs and KVM switches usually bring the keyboard back within a few seconds
+	reconnectTimeout  = 15 * time.Second
+	reconnectInterval = 500 * time.Millisecond
+	identityTimeout   = 5 * time.Second
+	maxReconnects     = 3
+)
+
+var ErrNoDevices = errors.New("no devices found")
+
+type ModelMismatchError struct {
+	Device  string
+	Profile string
+}
+
+func (e *ModelMismatchError) Error() string {
+	return fmt.Sprintf("profile model does not match device model (expected %s, got %s)", e.Device, e.Profile)
+}
+
+// State is what was sent to the keyboard, it can't be read back from the device
+type State struct {
+	Turbo        bool
+	RapidTrigger bool
+	Light        driver.DDLight
+	Actuations   []byte
+	Downstrokes  []byte
+	Upstrokes    []byte
+}
+
+type Keyboard struct {
+	device     *hid.Device
+	info       hid.DeviceInfo
+	serial     string
+	controller *driver.DrunkDeerController
+	identity   *driver.DDKeyboardIdentity // As reported when it was last opened
+	logger     *slog.Logger
+	closed     bool // device and controller were closed and not reopened since
+
+	// How Reconnect looks for the keyboard and opens it again, replaced in tests
+	devices func() []hid.DeviceInfo
+	connect func(ctx context.Context, info *hid.DeviceInfo) (*hid.Device, *driver.DrunkDeerController, *driver.DDKeyboardIdentity, error)
+}
+
+type Option func(*Keyboard)
+
+func WithLogger(logger *slog.Logger) Option {
+	return func(k *Keyboard) {
+		k.logger = logger
+	}
+}
+
+// Open opens the device and waits (up to a few seconds) for it to report its identity
+func Open(info *hid.DeviceInfo, opts ...Option) (*Keyboard, error) {
+	k := &Keyboard{
+		info:    *info,
+		serial:  info.SerialNbr,
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		devices: FindDrunkDeerDevices,
+	}
+	k.connect = k.connectDevice
+
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	device, err := hid.OpenPath(info.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device: %w", err)
+	}
+	k.device = device
+
+	if k.serial == "" {
+		k.serial, _ = device.GetSerialNbr()
+	}
+
+	k.logger = k.logger.With(slog.String("device", k.serial))
+	k.controller = driver.NewDrunkDeerControllerWithLogger(device, k.logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), identityTimeout)
+	defer cancel()
+
+	identity, err := k.controller.WaitIdentity(ctx)
+	if err != nil {
+		k.Close()
+		return nil, fmt.Errorf("device did not report its identity: %w", err)
+	}
+	k.identity = identity
+
+	return k, nil
+}
+
+// OpenIndex opens the n-th connected DrunkDeer keyboard
+func OpenIndex(index int, opts ...Option) (*Keyboard, error) {
+	devices := FindDrunkDeerDevices()
+	if len(devices) == 0 {
+		return nil, ErrNoDevices
+	}
+
+	if index < 0 || index >= len(devices) {
+		return nil, fmt.Errorf("invalid keyboard index: %d", index)
+	}
+
+	return Open(&devices[index], opts...)
+}
+
+// Identity is what the keyboard reported when it was opened, still there after it's closed
+func (k *Keyboard) Identity() *driver.DDKeyboardIdentity {
+	return k.identity
+}
+
+// Serial may be empty if the device doesn't report one
+func (k *Keyboard) Serial() string {
+	return k.serial
+}
+
+func (k *Keyboard) Info() hid.DeviceInfo {
+	return k.info
+}
+
+// Controller gives access to the low-level driver for anything this package doesn't cover
+func (k *Keyboard) Controller() *driver.DrunkDeerController {
+	return k.controller
+}
+
+// Apply sends the profile to the keyboard. If the device disconnects halfway through, it's reopened
+// by serial and the whole profile is sent again.
+func (k *Keyboard) Apply(ctx context.Context, profile *Profile) (*State, error) {
+	return k.withReconnect(ctx, func() (*State, error) {
+		return k.apply(ctx, profile)
+	})
+}
+
+func (k *Keyboard) apply(ctx context.Context, profile *Profile) (*State, error) {
+	model := k.Identity().KeyboardModel
+	if profile.Model != "" && profile.Model != model {
+		return nil, &ModelMismatchError{Device: model, Profile: profile.Model}
+	}
+
+	if errs := Errors(profile.Validate("")); len(errs) > 0 {
+		return nil, &ValidationError{Issues: errs}
+	}
+
+	actuations, downstrokes, upstrokes, err := profile.Tables()
+	if err != nil {
+		return nil, err
+	}
+
+	k.logger.Debug("applying profile",
+		slog.String("model", profile.Model),
+		slog.Bool("turbo", profile.Turbo),
+		slog.Bool("rapidTrigger", profile.RapidTrigger.Enabled),
+		slog.Float64("defaultActuation", float64(profile.DefaultActuation)))
+
+	state := &State{
+		Turbo:        profile.Turbo,
+		RapidTrigger: profile.RapidTrigger.Enabled,
+		Light:        *profile.Lights(),
+		Actuations:   actuations,
+		Downstrokes:  downstrokes,
+		Upstrokes:    upstrokes,
+	}
+
+	if err := k.applyState(ctx, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// ApplyState sends already resolved tables, e.g. to restore a previously recorded state
+func (k *Keyboard) ApplyState(ctx context.Context, state *State) error {
+	_, err := k.withReconnect(ctx, func() (*State, error) {
+		if err := k.applyState(ctx, state); err != nil {
+			return nil, err
+		}
+		return state, nil
+	})
+	return err
+}
+
+func (k *Keyboard) applyState(ctx context.Context, state *State) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range [][]byte{state.Actuations, state.Downstrokes, state.Upstrokes} {
+		if len(table) != len(driver.KEYBOARD_LAYOUT) {
+			return fmt.Errorf("table has %d keys, the layout has %d", len(table), len(driver.KEYBOARD_LAYOUT))
+		}
+	}
+
+	light := state.Light
+	k.controller.Light = &light
+	err := errors.Join(
+		k.controller.SendRapidTriggerTurbo(state.RapidTrigger, state.Turbo),
+		k.controller.SendLEDModeSelect(light.Direction, light.Sequence, light.Speed, light.Brightness, 0xff),
+		k.controller.LoadActuations(state.Actuations),
+		k.controller.LoadDownstrokes(state.Downstrokes),
+		k.controller.LoadUpstrokes(state.Upstrokes),
+	)
+	if err != nil {
+		return err
+	}
+
+	return k.controller.Flush(ctx)
+}
+
+// Reset writes the firmware defaults
+func (k *Keyboard) Reset(ctx context.Context) (*State, error) {
+	return k.withReconnect(ctx, func() (*State, error) {
+		if err := k.controller.WriteDefaults(); err != nil {
+			return nil, err
+		}
+
+		if err := k.controller.Flush(ctx); err != nil {
+			return nil, err
+		}
+
+		return &State{
+			Light:       *k.controller.Light,
+			Actuations:  k.controller.GetActuations(),
+			Downstrokes: k.controller.GetDownstrokes(),
+			Upstrokes:   k.controller.GetUpstrokes(),
+		}, nil
+	})
+}
+
+func (k *Keyboard) withReconnect(ctx context.Context, fn func() (*State, error)) (*State, error) {
+	for attempt := 1; ; attempt++ {
+		state, err := fn()
+		if !errors.Is(err, driver.ErrDisconnected) || attempt > maxReconnects {
+			return state, err
+		}
+
+		k.logger.Warn("device disconnected mid-apply, reconnecting", slog.Int("attempt", attempt))
+		if err := k.Reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Reconnect waits for the same keyboard (matched by serial, or by path if it has none) to come back
+// and replaces the controller. If it doesn't come back, or comes back as another model, the keyboard is left
+// closed.
+func (k *Keyboard) Reconnect(ctx context.Context) error {
+	model := ""
+	if k.identity != nil {
+		model = k.identity.KeyboardModel
+	}
+
+	k.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, reconnectTimeout)
+	defer cancel()
+
+	for {
+		if info := k.find(); info != nil {
+			err := k.reopen(ctx, info)
+			if err == nil {
+				break
+			}
+			k.logger.Debug("reopen failed", slog.Any("err", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("device did not come back: %w", ctx.Err())
+		case <-time.After(reconnectInterval):
+		}
+	}
+
+	if model != "" && k.identity.KeyboardModel != model {
+		k.Close()
+		return fmt.Errorf("reconnected device reports %s, expected %s", k.identity.KeyboardModel, model)
+	}
+
+	k.logger.Info("device reconnected", slog.String("model", k.identity.KeyboardModel))
+	return nil
+}
+
+func (k *Keyboard) find() *hid.DeviceInfo {
+	for _, info := range k.devices() {
+		if k.serial != "" && info.SerialNbr == k.serial {
+			return &info
+		}
+
+		if k.serial == "" && info.Path == k.info.Path {
+			return &info
+		}
+	}
+
+	return nil
+}
+
+func (k *Keyboard) reopen(ctx context.Context, info *hid.DeviceInfo) error {
+	device, controller, identity, err := k.connect(ctx, info)
+	if err != nil {
+		return err
+	}
+
+	k.info = *info
+	k.device = device
+	k.controller = controller
+	k.identity = identity
+	k.closed = false
+	return nil
+}
+
+func (k *Keyboard) connectDevice(ctx context.Context, info *hid.DeviceInfo) (*hid.Device, *driver.DrunkDeerController, *driver.DDKeyboardIdentity, error) {
+	device, err := hid.OpenPath(info.Path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	controller := driver.NewDrunkDeerControllerWithLogger(device, k.logger)
+
+	ctx, cancel := context.WithTimeout(ctx, identityTimeout)
+	defer cancel()
+
+	identity, err := controller.WaitIdentity(ctx)
+	if err != nil {
+		controller.Close()
+		device.Close()
+		return nil, nil, nil, err
+	}
+
+	return device, controller, identity, nil
+}
+
+// Close can be called more than once, e.g. after a failed Reconnect already closed the device
+func (k *Keyboard) Close() error {
+	if k.closed {
+		return nil
+	}
+	k.closed = true
+
+	var err error
+	if k.controller != nil {
+		err = k.controller.Close()
+	}
+	if k.device != nil {
+		if closeErr := k.device.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,91 @@
+package keyboard
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+	"github.com/sstallion/go-hid"
+)
+
+// A keyboard whose device went away, Reconnect finds the devices listed and reopens them as model
+func disconnectedKeyboard(serial string, devices []hid.DeviceInfo, model string) (*Keyboard, *[]string) {
+	opened := make([]string, 0)
+	k := &Keyboard{
+		serial:   serial,
+		identity: &driver.DDKeyboardIdentity{KeyboardModel: driver.KEYBOARD_A75},
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		devices:  func() []hid.DeviceInfo { return devices },
+	}
+	k.connect = func(ctx context.Context, info *hid.DeviceInfo) (*hid.Device, *driver.DrunkDeerController, *driver.DDKeyboardIdentity, error) {
+		opened = append(opened, info.Path)
+		return nil, nil, &driver.DDKeyboardIdentity{KeyboardModel: model}, nil
+	}
+	return k, &opened
+}
+
+func TestWithReconnect(t *testing.T) {
+	devices := []hid.DeviceInfo{
+		{Path: "/dev/hidraw1", SerialNbr: "OTHER"},
+		{Path: "/dev/hidraw2", SerialNbr: "ABC"},
+	}
+
+	tests := []struct {
+		name      string
+		serial    string
+		model     string
+		failures  int // Disconnects before fn succeeds
+		wantCalls int
+		wantErr   bool
+		wantOpen  []string
+	}{
+		{name: "disconnect mid-apply", serial: "ABC", model: driver.KEYBOARD_A75, failures: 1, wantCalls: 2, wantOpen: []string{"/dev/hidraw2"}},
+		{name: "model mismatch", serial: "ABC", model: driver.KEYBOARD_G65, failures: 1, wantCalls: 1, wantErr: true, wantOpen: []string{"/dev/hidraw2"}},
+		{name: "serial not found", serial: "GONE", model: driver.KEYBOARD_A75, failures: 1, wantCalls: 1, wantErr: true},
+		{name: "gives up", serial: "ABC", model: driver.KEYBOARD_A75, failures: 100, wantCalls: maxReconnects + 1, wantErr: true,
+			wantOpen: []string{"/dev/hidraw2", "/dev/hidraw2", "/dev/hidraw2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, opened := disconnectedKeyboard(test.serial, devices, test.model)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			calls := 0
+			_, err := k.withReconnect(ctx, func() (*State, error) {
+				calls++
+				if calls <= test.failures {
+					return nil, driver.ErrDisconnected
+				}
+				return &State{}, nil
+			})
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("fn ran %d times, expected %d", calls, test.wantCalls)
+			}
+			if len(*opened) != len(test.wantOpen) {
+				t.Fatalf("opened %v, expected %v", *opened, test.wantOpen)
+			}
+			for i := range test.wantOpen {
+				if (*opened)[i] != test.wantOpen[i] {
+					t.Errorf("opened %v, expected %v", *opened, test.wantOpen)
+				}
+			}
+			if test.wantErr && !k.closed && !errors.Is(err, driver.ErrDisconnected) {
+				t.Error("a failed reconnect should leave the keyboard closed")
+			}
+			if k.Identity() == nil {
+				t.Error("identity lost")
+			}
+		})
+	}
+}
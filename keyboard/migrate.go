@@ -0,0 +1,179 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SchemaVersion is the profile shape this build reads and writes. Profiles without a schemaVersion are version 0.
+const SchemaVersion = 1
+
+// migrations[i] upgrades a profile from version i to i+1 in place and reports whether it changed anything other
+// than the version. Add one here whenever the shape of Profile changes.
+var migrations = []func(raw map[string]any) bool{
+	// 0 -> 1: same shape, profiles just start carrying a version
+	func(raw map[string]any) bool { return false },
+}
+
+type SchemaVersionError struct {
+	Version int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("profile schema version %d is newer than this drunkdeer supports (%d), update drunkdeer to load it", e.Version, SchemaVersion)
+}
+
+// ProfileVersion reads the schemaVersion of a profile in any format without migrating it
+func ProfileVersion(data []byte, format Format) (int, error) {
+	converted, err := ToJSON(data, format)
+	if err != nil {
+		return 0, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(converted, &raw); err != nil {
+		return 0, err
+	}
+	return schemaVersion(raw)
+}
+
+// MigrateProfile upgrades a profile to SchemaVersion and writes it back out in the same format, along with the
+// version it had. Comments and key order are lost, so when the migrations only bump the version the data comes
+// back nil and the file is better left alone, it loads the same either way.
+func MigrateProfile(data []byte, format Format) ([]byte, int, error) {
+	converted, err := ToJSON(data, format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(converted, &raw); err != nil {
+		return nil, 0, err
+	}
+
+	from, changed, err := migrateRaw(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !changed {
+		return nil, from, nil
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Catches anything the migrations couldn't make sense of before it's written
+	if _, err := unmarshalProfile(migrated, "profile"); err != nil {
+		return nil, from, err
+	}
+
+	out, err := ConvertProfile(migrated, FormatJSON, format)
+	return out, from, err
+}
+
+// decodeProfile parses a profile in any format, upgrading older versions on the way. The raw fields are returned
+// too, migrated, for merging profiles.
+func decodeProfile(data []byte, format Format) (*Profile, map[string]any, error) {
+	converted, err := ToJSON(data, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(converted, &raw); err != nil {
+		// For the line and column
+		if _, parseErr := parseConverted(converted, format); parseErr != nil {
+			return nil, nil, parseErr
+		}
+		return nil, nil, err
+	}
+	if raw == nil {
+		raw = make(map[string]any)
+	}
+
+	from, changed, err := migrateRaw(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Nothing moved, so errors can still point into the file
+	if !changed {
+		profile, err := parseConverted(converted, format)
+		if err != nil {
+			return nil, nil, err
+		}
+		profile.SchemaVersion = SchemaVersion
+		return profile, raw, nil
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	profile, err := unmarshalProfile(migrated, fmt.Sprintf("profile upgraded from version %d", from))
+	return profile, raw, err
+}
+
+// Runs every migration the profile needs, returns the version it started at and whether anything but the
+// version changed
+func migrateRaw(raw map[string]any) (int, bool, error) {
+	from, err := schemaVersion(raw)
+	if err != nil {
+		return 0, false, err
+	}
+
+	changed := false
+	for version := from; version < SchemaVersion; version++ {
+		if migrations[version](raw) {
+			changed = true
+		}
+	}
+
+	if from < SchemaVersion {
+		raw["schemaVersion"] = SchemaVersion
+	}
+	return from, changed, nil
+}
+
+func schemaVersion(raw map[string]any) (int, error) {
+	value, ok := raw["schemaVersion"]
+	if !ok || value == nil {
+		return 0, nil
+	}
+
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != math.Trunc(number) {
+		return 0, fmt.Errorf("$.schemaVersion: expected a whole number, got %v", value)
+	}
+
+	if int(number) > SchemaVersion {
+		return 0, &SchemaVersionError{Version: int(number)}
+	}
+	return int(number), nil
+}
+
+// Parses JSON that came from the file in format, with positions if they still line up with the file
+func parseConverted(converted []byte, format Format) (*Profile, error) {
+	if format == FormatJSON || format == FormatJSONC {
+		return ParseProfile(converted)
+	}
+	return unmarshalProfile(converted, "profile "+string(format))
+}
+
+// Line numbers wouldn't match anything the user wrote, the field path is still right
+func unmarshalProfile(data []byte, what string) (*Profile, error) {
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("failed to parse %s: $.%s: expected %s, got %s", what, typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, fmt.Errorf("failed to parse %s: %w", what, err)
+	}
+	return &profile, nil
+}
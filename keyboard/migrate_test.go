@@ -0,0 +1,58 @@
+package keyboard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMigrateProfile(t *testing.T) {
+	old := []byte("model: A75\ndefaultActuation: 2\n")
+
+	profile, err := ParseProfileFormat(old, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.SchemaVersion != SchemaVersion || profile.Model != "A75" {
+		t.Errorf("expected an upgraded profile, got %+v", profile)
+	}
+
+	// Only the version would change, rewriting would just drop comments and reorder keys
+	migrated, from, err := MigrateProfile(old, FormatYAML)
+	if err != nil || from != 0 || migrated != nil {
+		t.Fatalf("expected nothing to write, got version %d %q (%v)", from, migrated, err)
+	}
+
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = []func(raw map[string]any) bool{
+		func(raw map[string]any) bool {
+			raw["defaultActuation"] = 2.5
+			return true
+		},
+	}
+
+	migrated, from, err = MigrateProfile(old, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 || !strings.Contains(string(migrated), "schemaVersion: 1") || !strings.Contains(string(migrated), "2.5") {
+		t.Errorf("expected version 0 upgraded to 1, got %d:\n%s", from, migrated)
+	}
+
+	if version, err := ProfileVersion(migrated, FormatYAML); err != nil || version != SchemaVersion {
+		t.Errorf("expected version %d after migrating, got %d (%v)", SchemaVersion, version, err)
+	}
+}
+
+func TestMigrateProfileFutureVersion(t *testing.T) {
+	var versionErr *SchemaVersionError
+	_, err := ParseProfileFormat([]byte(`{"schemaVersion": 99, "model": "A75"}`), FormatJSON)
+	if !errors.As(err, &versionErr) || versionErr.Version != 99 {
+		t.Fatalf("expected a SchemaVersionError, got %v", err)
+	}
+
+	if _, err := ParseProfileFormat([]byte(`{"schemaVersion": "2"}`), FormatJSON); err == nil {
+		t.Error("expected an error for a string version")
+	}
+}
@@ -0,0 +1,169 @@
+package keyboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+type RapidTriggerSettings struct {
+	Enabled           bool    `json:"enabled"`
+	DefaultDownstroke float32 `json:"defaultDownstroke"`
+	DefaultUpstroke   float32 `json:"defaultUpstroke"`
+}
+
+type LightSettings struct {
+	Enabled    bool `json:"enabled"`
+	Direction  int  `json:"direction"`
+	Sequence   int  `json:"sequence"`
+	Speed      int  `json:"speed"`
+	Brightness int  `json:"brightness"`
+}
+
+type Profile struct {
+	SchemaVersion int `json:"schemaVersion,omitempty"` // See SchemaVersion, missing is 0
+
+	// Name, path or URL of a profile this one is based on, only the fields set here override it
+	Extends string `json:"extends,omitempty"`
+
+	Model            string                `json:"model"`
+	RapidTrigger     RapidTriggerSettings  `json:"rapidTrigger"`
+	Turbo            bool                  `json:"turbo"`
+	DefaultActuation float32               `json:"defaultActuation"`
+	ActuationPoints  map[string]float32    `json:"actuationPoints"`
+	RapidTriggers    map[string][2]float32 `json:"rapidTriggers"`
+	Light            LightSettings         `json:"light"`
+
+	// Named sets of keys, usable as @name in actuationPoints and rapidTriggers
+	Groups map[string][]string `json:"groups,omitempty"`
+}
+
+// LoadProfile reads a profile from a file path or an http(s) URL, with anything it extends merged in
+func LoadProfile(source string) (*Profile, error) {
+	data, err := ReadProfile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return ResolveProfile(data, DetectFormat(source, data), source)
+}
+
+func ParseProfile(data []byte) (*Profile, error) {
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("failed to parse profile JSON: %w", describeJSONError(data, err))
+	}
+
+	return &profile, nil
+}
+
+// Points at where in the file the JSON went wrong
+func describeJSONError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := lineColumn(data, syntaxErr.Offset)
+		return fmt.Errorf("line %d, column %d: %w", line, col, err)
+	case errors.As(err, &typeErr):
+		line, col := lineColumn(data, typeErr.Offset)
+		return fmt.Errorf("$.%s (line %d, column %d): expected %s, got %s",
+			typeErr.Field, line, col, typeErr.Type, typeErr.Value)
+	}
+
+	return err
+}
+
+func lineColumn(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := 0; i < int(offset) && i < len(data); i++ {
+		if data[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// Tables resolves the profile into the per-key actuation, downstroke and upstroke tables sent to the keyboard
+func (p *Profile) Tables() ([]byte, []byte, []byte, error) {
+	actuations := make([]byte, len(driver.KEYBOARD_LAYOUT))
+	downstrokes := make([]byte, len(driver.KEYBOARD_LAYOUT))
+	upstrokes := make([]byte, len(driver.KEYBOARD_LAYOUT))
+
+	defaultAct := driver.ActuationFloatToByte(p.DefaultActuation)
+	defaultDS := driver.ActuationFloatToByte(p.RapidTrigger.DefaultDownstroke)
+	defaultUS := driver.ActuationFloatToByte(p.RapidTrigger.DefaultUpstroke)
+
+	for i := range actuations {
+		actuations[i] = defaultAct
+		downstrokes[i] = defaultDS
+		upstrokes[i] = defaultUS
+	}
+
+	points, err := ExpandSelectors(p.ActuationPoints, p.Groups)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("actuationPoints: %w", err)
+	}
+	for i, value := range points {
+		actuations[i] = driver.ActuationFloatToByte(value)
+	}
+
+	triggers, err := ExpandSelectors(p.RapidTriggers, p.Groups)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("rapidTriggers: %w", err)
+	}
+	for i, value := range triggers {
+		downstrokes[i] = driver.ActuationFloatToByte(value[0])
+		upstrokes[i] = driver.ActuationFloatToByte(value[1])
+	}
+
+	return actuations, downstrokes, upstrokes, nil
+}
+
+// Lights returns the light settings as sent to the keyboard, a disabled light is sequence off
+func (p *Profile) Lights() *driver.DDLight {
+	light := &driver.DDLight{
+		Sequence:   byte(p.Light.Sequence),
+		Speed:      byte(p.Light.Speed),
+		Direction:  byte(p.Light.Direction),
+		Brightness: byte(p.Light.Brightness),
+	}
+
+	if !p.Light.Enabled {
+		light.Sequence = driver.SEQUENCE_OFF
+	}
+
+	return light
+}
+
+func Download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch profile from URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return body, nil
+}
+
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http") && strings.Contains(path, "://")
+}
@@ -0,0 +1,143 @@
+package keyboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+const echoTimeout = 2 * time.Second
+
+// Recognisable values that differ from the firmware defaults, in 0.1mm units
+const (
+	selfTestActuation  = 0x15
+	selfTestDownstroke = 0x03
+	selfTestUpstroke   = 0x04
+)
+
+type SelfTestCheck struct {
+	Name  string // identity, ledmode, turbo, rapidtrigger or a table name
+	Row   int    // -1 unless it's a table row
+	Err   error
+	Echo  driver.DDPacket
+	Taken time.Duration
+}
+
+func (c *SelfTestCheck) Passed() bool {
+	return c.Err == nil
+}
+
+// Request sends a packet and waits for the first inbound packet accepted by match
+func (k *Keyboard) Request(ctx context.Context, packet []byte, match func(driver.DDPacket) bool) (driver.DDPacket, error) {
+	packets, unsubscribe := k.controller.Subscribe()
+	defer unsubscribe()
+
+	if err := k.controller.QueuePacket(packet); err != nil {
+		return driver.DDPacket{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, echoTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case p, ok := <-packets:
+			if !ok {
+				return driver.DDPacket{}, driver.ErrDisconnected
+			}
+			if match(p) {
+				return p, nil
+			}
+		case <-k.controller.Disconnected():
+			return driver.DDPacket{}, driver.ErrDisconnected
+		case <-ctx.Done():
+			return driver.DDPacket{}, fmt.Errorf("no echo: %w", ctx.Err())
+		}
+	}
+}
+
+// SelfTest sends every packet type this driver knows and checks the echoes. It leaves the keyboard
+// in a test state, restore it afterwards.
+func (k *Keyboard) SelfTest(ctx context.Context) []SelfTestCheck {
+	checks := make([]SelfTestCheck, 0)
+	run := func(name string, row int, packet []byte, verify func(driver.DDPacket) error) {
+		start := time.Now()
+		echo, err := k.Request(ctx, packet, func(p driver.DDPacket) bool {
+			return p.Packet == packet[0]
+		})
+		if err == nil {
+			err = verify(echo)
+		}
+		checks = append(checks, SelfTestCheck{Name: name, Row: row, Err: err, Echo: echo, Taken: time.Since(start)})
+	}
+
+	run("identity", -1, driver.BuildIdentity(), func(p driver.DDPacket) error {
+		_, err := driver.ParseIdentity(p.Data)
+		return err
+	})
+
+	run("ledmode", -1, driver.BuildLEDModeSelect(1, driver.SEQUENCE_ALWAYS, 3, 4, 0xff), func(p driver.DDPacket) error {
+		light, err := driver.ParseLEDMode(p.Data)
+		if err != nil {
+			return err
+		}
+		if light.Direction != 1 || light.Sequence != driver.SEQUENCE_ALWAYS || light.Speed != 3 || light.Brightness != 4 {
+			return fmt.Errorf("echoed %+v", *light)
+		}
+		return nil
+	})
+
+	for _, toggle := range []struct {
+		name      string
+		rt, turbo bool
+	}{{"turbo", false, true}, {"rapidtrigger", true, false}} {
+		run(toggle.name, -1, driver.BuildRapidTriggerTurbo(toggle.rt, toggle.turbo), func(p driver.DDPacket) error {
+			turbo, rt, err := driver.ParseTurboRT(p.Data)
+			if err != nil {
+				return err
+			}
+			if turbo != toggle.turbo || rt != toggle.rt {
+				return fmt.Errorf("echoed turbo=%v rt=%v", turbo, rt)
+			}
+			return nil
+		})
+	}
+
+	tables := []struct {
+		name  string
+		value byte
+		build func(uint8, []byte) []byte
+	}{
+		{"actuation", selfTestActuation, driver.BuildModifyRowActuation},
+		{"downstroke", selfTestDownstroke, driver.BuildModifyRowDownstroke},
+		{"upstroke", selfTestUpstroke, driver.BuildModifyRowUpstroke},
+	}
+
+	rows := (len(driver.KEYBOARD_LAYOUT) + driver.KEYS_PER_ROW - 1) / driver.KEYS_PER_ROW
+	for _, table := range tables {
+		for row := 0; row < rows; row++ {
+			keys := min(driver.KEYS_PER_ROW, len(driver.KEYBOARD_LAYOUT)-row*driver.KEYS_PER_ROW)
+			sent := bytes.Repeat([]byte{table.value}, keys)
+			packet := table.build(uint8(row), sent)
+
+			run(table.name, row, packet, func(p driver.DDPacket) error {
+				echo, err := driver.ParseModifyKey(p.Data)
+				if err != nil {
+					return err
+				}
+				if echo.Table != packet[1] || int(echo.Row) != row {
+					return fmt.Errorf("echoed %s row %d", driver.ModifyTableName(echo.Table), echo.Row)
+				}
+				if len(echo.Keys) < keys || !bytes.Equal(echo.Keys[:keys], sent) {
+					return fmt.Errorf("echoed keys %x", echo.Keys)
+				}
+				return nil
+			})
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,85 @@
+package keyboard
+
+import (
+	"context"
+	"time"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// Travel is the latest known depth of every key, indexed like driver.KEYBOARD_LAYOUT, in 0.1mm units
+type Travel struct {
+	Depths []byte
+	Row    int // Row that changed in this update
+	At     time.Time
+}
+
+// RowKeys is the index range of the row this update is for. The rest of Depths is what earlier updates
+// reported, or zeros for rows that haven't reported yet.
+func (t *Travel) RowKeys() (int, int) {
+	end := min((t.Row+1)*driver.KEYS_PER_ROW, len(t.Depths))
+	return min(t.Row*driver.KEYS_PER_ROW, end), end
+}
+
+func (t *Travel) DepthMM(index int) float32 {
+	if index < 0 || index >= len(t.Depths) {
+		return 0
+	}
+	return float32(t.Depths[index]) / 10
+}
+
+// TrackKeys turns on the key tracking stream and sends a snapshot for every row update until ctx is
+// done. Slow readers miss snapshots rather than stalling the device.
+func (k *Keyboard) TrackKeys(ctx context.Context) (<-chan Travel, error) {
+	controller := k.controller
+	packets, unsubscribe := controller.Subscribe()
+
+	if err := controller.QueuePacket(driver.BuildKeyTracking(true)); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan Travel, 16)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+		// Best effort, the device may already be gone
+		defer controller.QueuePacket(driver.BuildKeyTracking(false))
+
+		depths := make([]byte, len(driver.KEYBOARD_LAYOUT))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-controller.Disconnected():
+				return
+			case p, ok := <-packets:
+				if !ok {
+					return
+				}
+				if p.Packet != driver.PACKET_KEYTRACKING {
+					continue
+				}
+
+				tracking, err := driver.ParseKeyTracking(p.Data)
+				if err != nil {
+					continue
+				}
+
+				copy(depths[int(tracking.Row)*driver.KEYS_PER_ROW:], tracking.Depths)
+				snapshot := Travel{
+					Depths: append([]byte(nil), depths...),
+					Row:    int(tracking.Row),
+					At:     time.Now(),
+				}
+
+				select {
+				case out <- snapshot:
+				default:
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
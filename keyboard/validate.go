@@ -0,0 +1,324 @@
+package keyboard
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/2xxn/cli-drunkdeer/driver"
+)
+
+// Limits for profile values, in mm
+const (
+	MinActuation    = 0.1
+	MaxActuation    = 3.9
+	MaxRapidTrigger = 3.9
+	MaxLightLevel   = 9 // Speed and brightness
+)
+
+var knownModels = []string{driver.KEYBOARD_A75, driver.KEYBOARD_G65, driver.KEYBOARD_G60, driver.KEYBOARD_G75}
+
+type ValidationIssue struct {
+	Path       string // JSON path into the profile, e.g. $.actuationPoints.w
+	Message    string
+	Suggestion string // Empty if there's nothing better to offer
+	Warning    bool   // Loads anyway, but probably isn't what was meant
+}
+
+func (i ValidationIssue) String() string {
+	s := i.Path + ": " + i.Message
+	if i.Suggestion != "" {
+		s += " (did you mean " + i.Suggestion + "?)"
+	}
+	return s
+}
+
+// Returned by Apply when the profile has errors, warnings don't stop it
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		if !issue.Warning {
+			lines = append(lines, issue.String())
+		}
+	}
+	return "invalid profile: " + strings.Join(lines, "; ")
+}
+
+// Validate checks every value in the profile. deviceModel is the connected keyboard's model, or empty to skip
+// the model mismatch check. Issues come sorted by path.
+func (p *Profile) Validate(deviceModel string) []ValidationIssue {
+	v := &validator{groups: p.Groups}
+	v.userGroups(p.Groups)
+
+	switch {
+	case p.Model == "":
+	case !containsString(knownModels, p.Model):
+		v.add("$.model", fmt.Sprintf("unknown model %q", p.Model), closest(p.Model, knownModels))
+	case deviceModel != "" && p.Model != deviceModel:
+		v.add("$.model", fmt.Sprintf("profile is for %s but the keyboard is a %s", p.Model, deviceModel), "")
+	}
+
+	v.actuation("$.defaultActuation", p.DefaultActuation)
+	seen := make(map[int]string)
+	for _, key := range sortedKeys(p.ActuationPoints) {
+		path := "$.actuationPoints" + jsonKey(key)
+		if v.key(path, key, seen) {
+			v.actuation(path, p.ActuationPoints[key])
+		}
+	}
+
+	v.rapidTrigger("$.rapidTrigger.defaultDownstroke", p.RapidTrigger.DefaultDownstroke)
+	v.rapidTrigger("$.rapidTrigger.defaultUpstroke", p.RapidTrigger.DefaultUpstroke)
+	if p.RapidTrigger.Enabled && p.RapidTrigger.DefaultDownstroke == 0 && p.RapidTrigger.DefaultUpstroke == 0 && len(p.RapidTriggers) == 0 {
+		v.warn("$.rapidTrigger", "rapid trigger is enabled but every distance is 0")
+	}
+	seen = make(map[int]string)
+	for _, key := range sortedKeys(p.RapidTriggers) {
+		path := "$.rapidTriggers" + jsonKey(key)
+		if v.key(path, key, seen) {
+			v.rapidTrigger(path+"[0]", p.RapidTriggers[key][0])
+			v.rapidTrigger(path+"[1]", p.RapidTriggers[key][1])
+		}
+	}
+
+	v.level("$.light.speed", p.Light.Speed)
+	v.level("$.light.brightness", p.Light.Brightness)
+	v.sequence("$.light.sequence", p.Light.Sequence)
+	v.direction("$.light.direction", p.Light.Direction)
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		return v.issues[i].Path < v.issues[j].Path
+	})
+	return v.issues
+}
+
+// Errors returns only the issues that stop a profile from loading
+func Errors(issues []ValidationIssue) []ValidationIssue {
+	result := make([]ValidationIssue, 0)
+	for _, issue := range issues {
+		if !issue.Warning {
+			result = append(result, issue)
+		}
+	}
+	return result
+}
+
+type validator struct {
+	groups map[string][]string
+	issues []ValidationIssue
+}
+
+func (v *validator) add(path, message, suggestion string) {
+	v.issues = append(v.issues, ValidationIssue{Path: path, Message: message, Suggestion: suggestion})
+}
+
+func (v *validator) warn(path, message string) {
+	v.issues = append(v.issues, ValidationIssue{Path: path, Message: message, Warning: true})
+}
+
+// Aliases make it possible to name the same key twice, seen maps indexes to the name used first
+func (v *validator) key(path, key string, seen map[int]string) bool {
+	if IsSelector(key) {
+		return v.selector(path, key)
+	}
+
+	i, err := driver.ResolveKey(key)
+	if err != nil {
+		var keyErr *driver.KeyError
+		if errors.As(err, &keyErr) && keyErr.Ambiguous() {
+			v.add(path, fmt.Sprintf("%q could be any of %d keys", key, len(keyErr.Candidates)), strings.Join(keyErr.Candidates, ", "))
+		} else {
+			v.add(path, fmt.Sprintf("unknown key %q", key), closest(key, layoutKeys()))
+		}
+		return false
+	}
+
+	if first, ok := seen[i]; ok {
+		v.add(path, fmt.Sprintf("%q is the same key as %q (%s)", key, first, driver.KEYBOARD_LAYOUT[i]), "")
+		return false
+	}
+
+	seen[i] = key
+	return true
+}
+
+func (v *validator) selector(path, name string) bool {
+	if _, err := ResolveSelector(name, v.groups); err != nil {
+		// Only suggest a group name when the group itself is unknown, not something inside it
+		suggestion := ""
+		var groupErr *UnknownGroupError
+		if errors.As(err, &groupErr) && groupErr.Name == name {
+			candidates := BuiltinGroups()
+			for group := range v.groups {
+				if !containsString(candidates, SelectorPrefix+group) {
+					candidates = append(candidates, SelectorPrefix+group)
+				}
+			}
+			suggestion = closest(name, candidates)
+		}
+		v.add(path, err.Error(), suggestion)
+		return false
+	}
+	return true
+}
+
+// User groups can't shadow built-in ones and every member has to resolve
+func (v *validator) userGroups(groups map[string][]string) {
+	for _, name := range sortedKeys(groups) {
+		path := "$.groups" + jsonKey(name)
+		if _, builtin := builtinGroups[strings.ToLower(name)]; builtin || strings.HasPrefix(strings.ToLower(name), "row:") {
+			v.add(path, fmt.Sprintf("%s is a built-in group", SelectorPrefix+name), "")
+			continue
+		}
+
+		for i, member := range groups[name] {
+			memberPath := fmt.Sprintf("%s[%d]", path, i)
+			if IsSelector(member) {
+				v.selector(memberPath, member)
+			} else if _, err := driver.ResolveKey(member); err != nil {
+				v.add(memberPath, err.Error(), closest(member, layoutKeys()))
+			}
+		}
+	}
+}
+
+func (v *validator) actuation(path string, mm float32) {
+	if mm < MinActuation || mm > MaxActuation {
+		v.add(path, fmt.Sprintf("actuation %.2fmm is outside %.1f-%.1fmm", mm, MinActuation, MaxActuation), "")
+	} else if mm < 0.2 {
+		v.warn(path, fmt.Sprintf("actuation %.2fmm is likely to fire on its own, 0.2mm is the practical minimum", mm))
+	}
+}
+
+func (v *validator) rapidTrigger(path string, mm float32) {
+	if mm < 0 || mm > MaxRapidTrigger {
+		v.add(path, fmt.Sprintf("distance %.2fmm is outside 0.0-%.1fmm", mm, MaxRapidTrigger), "")
+	}
+}
+
+func (v *validator) level(path string, level int) {
+	if level < 0 || level > MaxLightLevel {
+		v.add(path, fmt.Sprintf("%d is outside 0-%d", level, MaxLightLevel), "")
+	}
+}
+
+func (v *validator) sequence(path string, sequence int) {
+	switch {
+	case sequence == driver.SEQUENCE_CUSTOM:
+		v.warn(path, "custom colors aren't supported yet, the keyboard keeps its current colors")
+	case sequence != driver.SEQUENCE_OFF && (sequence < driver.SEQUENCE_ALWAYS || sequence > driver.SEQUENCE_CUSTOM):
+		v.add(path, fmt.Sprintf("unknown sequence %d, use 0 or %d-%d", sequence, driver.SEQUENCE_ALWAYS, driver.SEQUENCE_CUSTOM), "")
+	}
+}
+
+// Sent as a single byte, anything bigger would wrap around
+func (v *validator) direction(path string, direction int) {
+	if direction < 0 || direction > 0xff {
+		v.add(path, fmt.Sprintf("%d is outside 0-255", direction), "")
+	}
+}
+
+func layoutKeys() []string {
+	keys := make([]string, 0, len(driver.KEYBOARD_LAYOUT))
+	for _, key := range driver.KEYBOARD_LAYOUT {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Bracket notation when the key wouldn't be a valid identifier in a dotted path
+func jsonKey(key string) string {
+	if key == "" || key[0] >= '0' && key[0] <= '9' {
+		return fmt.Sprintf("[%q]", key)
+	}
+
+	for _, r := range key {
+		if !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return fmt.Sprintf("[%q]", key)
+		}
+	}
+	return "." + key
+}
+
+// Best candidates for a typo, case-insensitive matches first, then by edit distance
+func closest(name string, candidates []string) string {
+	upper := strings.ToUpper(name)
+	for _, candidate := range candidates {
+		if strings.ToUpper(candidate) == upper {
+			return candidate
+		}
+	}
+
+	type match struct {
+		name     string
+		distance int
+	}
+
+	maxDistance := max(2, (len(upper)+1)/2)
+	matches := make([]match, 0)
+	for _, candidate := range candidates {
+		d := levenshtein(upper, strings.ToUpper(candidate))
+		if d <= maxDistance || strings.HasPrefix(strings.ToUpper(candidate), upper) && len(upper) >= 2 {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	// Only the closest ones, "WW" should suggest W and not every two-letter key
+	names := make([]string, 0, 3)
+	for i := 0; i < len(matches) && i < 3 && matches[i].distance == matches[0].distance; i++ {
+		names = append(names, matches[i].name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package keyboard
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	profile := &Profile{
+		Model:            "A75",
+		DefaultActuation: 2.0,
+		ActuationPoints:  map[string]float32{"SHIFT": 0.5, "TAB": 5, "w": 1, "W": 1.5, "WW": 1},
+		RapidTriggers:    map[string][2]float32{"A": {0.2, 4.5}},
+		Light:            LightSettings{Speed: 12, Brightness: 9, Sequence: 1, Direction: 256},
+	}
+
+	want := map[string]string{
+		"$.actuationPoints.SHIFT": "SHF_L, SHF_R",
+		"$.actuationPoints.TAB":   "",
+		"$.actuationPoints.w":     "", // Same key as W
+		"$.actuationPoints.WW":    "W",
+		"$.rapidTriggers.A[1]":    "",
+		"$.light.speed":           "",
+		"$.light.sequence":        "",
+		"$.light.direction":       "",
+		"$.model":                 "",
+	}
+
+	issues := profile.Validate("G65")
+	if len(issues) != len(want) {
+		t.Fatalf("expected %d issues, got %v", len(want), issues)
+	}
+
+	for _, issue := range issues {
+		suggestion, ok := want[issue.Path]
+		if !ok {
+			t.Errorf("unexpected issue %s", issue)
+		} else if issue.Suggestion != suggestion {
+			t.Errorf("%s: expected suggestion %q, got %q", issue.Path, suggestion, issue.Suggestion)
+		}
+	}
+
+	grouped := &Profile{
+		DefaultActuation: 2.0,
+		Light:            LightSettings{Speed: 5, Brightness: 9, Sequence: 5},
+		Groups:           map[string][]string{"wasd": {"W"}, "mine": {"Q", "QQ"}},
+		ActuationPoints:  map[string]float32{"@wsad": 1, "@mine": 1, "@row:1": 1},
+	}
+
+	want = map[string]string{
+		"$.groups.wasd":              "",
+		"$.groups.mine[1]":           "Q",
+		`$.actuationPoints["@wsad"]`: "@wasd",
+		`$.actuationPoints["@mine"]`: "",
+	}
+
+	issues = grouped.Validate("")
+	if len(issues) != len(want) {
+		t.Fatalf("expected %d issues, got %v", len(want), issues)
+	}
+	for _, issue := range issues {
+		if suggestion, ok := want[issue.Path]; !ok || issue.Suggestion != suggestion {
+			t.Errorf("unexpected issue %s", issue)
+		}
+	}
+
+	valid := &Profile{DefaultActuation: 2.0, Light: LightSettings{Speed: 5, Brightness: 9, Sequence: 5}}
+	if issues := valid.Validate(""); len(issues) != 0 {
+		t.Fatalf("expected no issues, got %v", issues)
+	}
+}